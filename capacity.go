@@ -0,0 +1,97 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	resourcehelper "k8s.io/kubectl/pkg/util/resource"
+)
+
+// nodeCapacity is the allocatable CPU/memory of a node and the sum of the
+// requests of the matched pods on it in the --node-capacity output.
+type nodeCapacity struct {
+	node                        string
+	cpuRequests, cpuAllocatable resource.Quantity
+	memRequests, memAllocatable resource.Quantity
+}
+
+// nodeCapacities returns the capacity of each of the given nodes (sorted by
+// name) with the effective requests of the pods in resp summed up: like the
+// scheduler counts them, a pod requests the larger of its init containers'
+// and the sum of its containers' requests, plus its overhead. Pods in a
+// terminal phase are skipped since they no longer hold their requests. The
+// nodes are keyed by nodeKey with the pods' contexts.
+func nodeCapacities(resp metav1.Table, nodes map[string]*corev1.Node, podContexts map[types.UID]string) []nodeCapacity {
+	byNode := make(map[string]*nodeCapacity, len(nodes))
+	for name, node := range nodes {
+		byNode[name] = &nodeCapacity{
+			node:           name,
+			cpuAllocatable: node.Status.Allocatable.Cpu().DeepCopy(),
+			memAllocatable: node.Status.Allocatable.Memory().DeepCopy(),
+		}
+	}
+	for _, row := range resp.Rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		c, ok := byNode[nodeKey(podContexts[pod.UID], pod.Spec.NodeName)]
+		if !ok || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests, _ := resourcehelper.PodRequestsAndLimits(pod)
+		c.cpuRequests.Add(*requests.Cpu())
+		c.memRequests.Add(*requests.Memory())
+	}
+	out := make([]nodeCapacity, 0, len(byNode))
+	for _, c := range byNode {
+		out = append(out, *c)
+	}
+	slices.SortFunc(out, func(a, b nodeCapacity) int { return strings.Compare(a.node, b.node) })
+	return out
+}
+
+// utilizationPercent returns the requests as a percentage of the allocatable
+// amount (rounded down), or 0 if nothing is allocatable.
+func utilizationPercent(requests, allocatable resource.Quantity) int64 {
+	if allocatable.IsZero() {
+		return 0
+	}
+	return int64(float64(requests.MilliValue()) / float64(allocatable.MilliValue()) * 100)
+}
+
+// formatUtilization formats the requests and allocatable amounts of a
+// resource as a table cell (e.g. "1500m/4 (37%)").
+func formatUtilization(requests, allocatable resource.Quantity) string {
+	return fmt.Sprintf("%s/%s (%d%%)", requests.String(), allocatable.String(), utilizationPercent(requests, allocatable))
+}
+
+// printNodeCapacity prints the node capacities to w as a table.
+func printNodeCapacity(w io.Writer, capacities []nodeCapacity) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tCPU-REQ/ALLOC\tMEM-REQ/ALLOC")
+	for _, c := range capacities {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.node,
+			formatUtilization(c.cpuRequests, c.cpuAllocatable),
+			formatUtilization(c.memRequests, c.memAllocatable))
+	}
+	return tw.Flush()
+}
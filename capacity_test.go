@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNodeCapacities(t *testing.T) {
+	node := func(cpu, mem string) *corev1.Node {
+		return &corev1.Node{Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(mem),
+		}}}
+	}
+	pod := func(node string, phase corev1.PodPhase, requests ...string) metav1.TableRow {
+		p := &corev1.Pod{Spec: corev1.PodSpec{NodeName: node}, Status: corev1.PodStatus{Phase: phase}}
+		for i := 0; i < len(requests); i += 2 {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(requests[i]),
+					corev1.ResourceMemory: resource.MustParse(requests[i+1]),
+				},
+			}})
+		}
+		return metav1.TableRow{Object: runtime.RawExtension{Object: p}}
+	}
+	nodes := map[string]*corev1.Node{
+		"n2": node("4", "16Gi"),
+		"n1": node("2", "8Gi"),
+		"n3": node("1", "1Gi"),
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		pod("n1", corev1.PodRunning, "500m", "1Gi", "250m", "1Gi"),
+		pod("n1", corev1.PodPending, "250m", "2Gi"),
+		pod("n1", corev1.PodSucceeded, "1", "4Gi"),
+		pod("n2", corev1.PodRunning, "3", "4Gi"),
+		pod("n2", corev1.PodRunning), // no requests
+		pod("other", corev1.PodRunning, "1", "1Gi"),
+	}}
+
+	got := nodeCapacities(resp, nodes, nil)
+	require.Len(t, got, 3)
+	require.Equal(t, []string{"n1", "n2", "n3"}, []string{got[0].node, got[1].node, got[2].node})
+	require.Equal(t, "1", got[0].cpuRequests.String())
+	require.Equal(t, "4Gi", got[0].memRequests.String())
+	require.Equal(t, "3", got[1].cpuRequests.String())
+	require.True(t, got[2].cpuRequests.IsZero())
+	require.True(t, got[2].memRequests.IsZero())
+
+	// a pod requests the larger of its init containers' and the sum of its
+	// containers' requests, plus its overhead
+	initPod := pod("n3", corev1.PodRunning, "100m", "100Mi", "100m", "100Mi")
+	initPod.Object.Object.(*corev1.Pod).Spec.InitContainers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("50Mi")},
+	}}}
+	initPod.Object.Object.(*corev1.Pod).Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")}
+	withInit := nodeCapacities(metav1.Table{Rows: []metav1.TableRow{initPod}}, map[string]*corev1.Node{"n3": nodes["n3"]}, nil)
+	require.Equal(t, "510m", withInit[0].cpuRequests.String())
+	require.Equal(t, "200Mi", withInit[0].memRequests.String())
+
+	// the nodes of different contexts with the same name are kept apart
+	ctxPod := pod("n1", corev1.PodRunning, "1", "1Gi")
+	ctxPod.Object.Object.(*corev1.Pod).UID = "uid1"
+	byContext := nodeCapacities(metav1.Table{Rows: []metav1.TableRow{ctxPod}},
+		map[string]*corev1.Node{nodeKey("a", "n1"): nodes["n1"], nodeKey("b", "n1"): nodes["n1"]},
+		map[types.UID]string{"uid1": "b"})
+	require.Equal(t, []string{"a/n1", "b/n1"}, []string{byContext[0].node, byContext[1].node})
+	require.True(t, byContext[0].cpuRequests.IsZero())
+	require.Equal(t, "1", byContext[1].cpuRequests.String())
+
+	// only the requests of the matched pods are summed, e.g. DaemonSet pods
+	// unless --include-daemonsets is given
+	dsPod := pod("n1", corev1.PodRunning, "1", "1Gi")
+	dsPod.Object.Object.(*corev1.Pod).OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds"}}
+	podsWithDS := metav1.Table{Rows: []metav1.TableRow{pod("n1", corev1.PodRunning, "500m", "1Gi"), dsPod}}
+	matched := nodeCapacities(podFilters{}.apply(podsWithDS, time.Now()), map[string]*corev1.Node{"n1": nodes["n1"]}, nil)
+	require.Equal(t, "500m", matched[0].cpuRequests.String())
+	matched = nodeCapacities(podFilters{includeDaemonSets: true}.apply(podsWithDS, time.Now()), map[string]*corev1.Node{"n1": nodes["n1"]}, nil)
+	require.Equal(t, "1500m", matched[0].cpuRequests.String())
+
+	var buf bytes.Buffer
+	require.NoError(t, printNodeCapacity(&buf, got))
+	require.Equal(t, `NODE   CPU-REQ/ALLOC   MEM-REQ/ALLOC
+n1     1/2 (50%)       4Gi/8Gi (50%)
+n2     3/4 (75%)       4Gi/16Gi (25%)
+n3     0/1 (0%)        0/1Gi (0%)
+`, buf.String())
+}
+
+func TestUtilizationPercent(t *testing.T) {
+	for _, tc := range []struct {
+		requests, allocatable string
+		want                  int64
+	}{
+		{"1500m", "4", 37},
+		{"2", "2", 100},
+		{"3", "2", 150},
+		{"0", "2", 0},
+		{"1", "0", 0},
+		{"1Gi", "3Gi", 33},
+		{"100Mi", "64Gi", 0},
+	} {
+		require.Equal(t, tc.want, utilizationPercent(resource.MustParse(tc.requests), resource.MustParse(tc.allocatable)),
+			"%s/%s", tc.requests, tc.allocatable)
+	}
+}
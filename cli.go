@@ -15,23 +15,108 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/klog/v2"
 	kubectlget "k8s.io/kubectl/pkg/cmd/get"
+	"k8s.io/utils/ptr"
 )
 
-func addKlogFlags(flagSet *pflag.FlagSet) {
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// addKlogFlags adds the klog flags, --quiet/-q and --log-format. The returned
+// function configures klog after the flags are parsed, and reports whether
+// --quiet is set.
+func addKlogFlags(flagSet *pflag.FlagSet) (applyLogFlags func() (quiet bool, err error)) {
 	klogFlagSet := flag.NewFlagSet("ignored", flag.ExitOnError)
 	klog.InitFlags(klogFlagSet)
 	flagSet.AddGoFlagSet(klogFlagSet)
+	quiet := flagSet.BoolP("quiet", "q", false, "don't print anything but errors to stderr (no info/warning logs or progress)")
+	logFormat := flagSet.String("log-format", logFormatText, "format of the logs printed to stderr (text, json)")
+	return func() (bool, error) {
+		return *quiet, setKlogOutput(klogFlagSet, os.Stderr, *logFormat, *quiet)
+	}
+}
+
+// setKlogOutput configures klog to print the logs to w in the given format,
+// and only the errors if quiet is set.
+func setKlogOutput(klogFlagSet *flag.FlagSet, w io.Writer, format string, quiet bool) error {
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("invalid --log-format value %q (expected %s or %s)", format, logFormatText, logFormatJSON)
+	}
+	if quiet {
+		if err := klogFlagSet.Set("v", "0"); err != nil {
+			return fmt.Errorf("failed to set klog verbosity: %w", err)
+		}
+	} else if format == logFormatText {
+		return nil // klog's default
+	}
+	// klog writes all severities to stderr (with -logtostderr, the default),
+	// so filter and convert the formatted log lines by their header. Unlike
+	// -stderrthreshold (which requires -logtostderr=false), this doesn't dump
+	// the goroutine stacks on fatal errors.
+	klog.SetLoggerWithOptions(logr.Discard(), klog.WriteKlogBuffer(klogWriter(w, format, quiet)))
+	return nil
+}
+
+// klogLevels are the levels of the klog severities (the first character of
+// the log line header) in JSON logs.
+var klogLevels = map[byte]string{'I': "info", 'W': "warning", 'E': "error", 'F': "fatal"}
+
+// klogWriter returns a function that writes the formatted klog lines to w,
+// converted to JSON objects in json format, and only the error and fatal ones
+// if errorsOnly is set.
+func klogWriter(w io.Writer, format string, errorsOnly bool) func([]byte) {
+	return func(data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		if errorsOnly && data[0] != 'E' && data[0] != 'F' {
+			return
+		}
+		if format != logFormatJSON {
+			_, _ = w.Write(data)
+			return
+		}
+		// header: Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+		header, msg, ok := strings.Cut(string(data), "] ")
+		if !ok {
+			header, msg = "", string(data)
+		}
+		var caller string
+		if fields := strings.Fields(header); len(fields) > 0 {
+			caller = fields[len(fields)-1]
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			Time   string `json:"ts"`
+			Level  string `json:"level"`
+			Caller string `json:"caller,omitempty"`
+			Msg    string `json:"msg"`
+		}{time.Now().UTC().Format(time.RFC3339Nano), klogLevels[data[0]], caller, strings.TrimSuffix(msg, "\n")})
+	}
 }
 
 func addConfigFlags(flagSet *pflag.FlagSet) *genericclioptions.ConfigFlags {
@@ -48,14 +133,46 @@ func addPrintFlags(flagSet *pflag.FlagSet) *kubectlget.PrintFlags {
 	return printFlags
 }
 
-func parsePosArgs(posArgs []string) (selectors []labels.Selector, nodeNames []string, err error) {
+// posArgsMode is how the positional arguments are interpreted.
+type posArgsMode string
+
+const (
+	// posArgsAuto tells node names and selectors apart with a heuristic.
+	posArgsAuto posArgsMode = ""
+	// posArgsNodesOnly treats every argument as node names (--nodes-only).
+	posArgsNodesOnly posArgsMode = "nodes"
+	// posArgsSelectorsOnly treats every argument as a node selector
+	// (--selectors-only).
+	posArgsSelectorsOnly posArgsMode = "selectors"
+	// posArgsAllNodes selects all the nodes, and takes no arguments
+	// (--all-nodes).
+	posArgsAllNodes posArgsMode = "all"
+)
+
+func parsePosArgs(posArgs []string, mode posArgsMode) (selectors []labels.Selector, nodeNames []string, err error) {
+	if mode == posArgsAllNodes {
+		if len(posArgs) > 0 {
+			return nil, nil, fmt.Errorf("node names or selectors (%v) can't be specified with --all-nodes", posArgs)
+		}
+		return []labels.Selector{labels.Everything()}, nil, nil
+	}
 	if len(posArgs) == 0 {
 		return nil, nil, errors.New("no positional arguments specified. specify node names or node selectors")
 	}
 	for _, arg := range posArgs {
-		// selector heuristic: contains = or " "
-		if !strings.ContainsAny(arg, "= ") {
-			nodeNames = append(nodeNames, arg)
+		// selector heuristic: contains =, !, " " or parentheses (node names
+		// can't contain these, and "!key" selects nodes without the label)
+		isSelector := strings.ContainsAny(arg, "=! ()")
+		if mode != posArgsAuto {
+			isSelector = mode == posArgsSelectorsOnly
+		}
+		if !isSelector {
+			// may be a comma-separated list of node names
+			for _, name := range strings.Split(arg, ",") {
+				if name != "" {
+					nodeNames = append(nodeNames, name)
+				}
+			}
 			continue
 		}
 		selector, err := labels.Parse(arg)
@@ -66,3 +183,231 @@ func parsePosArgs(posArgs []string) (selectors []labels.Selector, nodeNames []st
 	}
 	return
 }
+
+// parseSelectorsFile parses the node selectors in r, one per line. Blank lines
+// and lines starting with # are skipped.
+func parseSelectorsFile(r io.Reader) ([]labels.Selector, error) {
+	var selectors []labels.Selector
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		selector, err := labels.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: failed to parse node selector %q: %w", lineNum, line, err)
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, scanner.Err()
+}
+
+// nodeSelectableFields are the node fields the API server supports in field
+// selectors.
+var nodeSelectableFields = sets.New("metadata.name", "spec.unschedulable")
+
+// parseNodeFieldSelector parses the --node-field-selector value, and checks
+// that it only selects on the fields supported for nodes.
+func parseNodeFieldSelector(s string) (fields.Selector, error) {
+	sel, err := fields.ParseSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range sel.Requirements() {
+		if !nodeSelectableFields.Has(req.Field) {
+			return nil, fmt.Errorf("field %q is not supported for nodes (supported fields: %s)",
+				req.Field, strings.Join(sets.List(nodeSelectableFields), ", "))
+		}
+	}
+	return sel, nil
+}
+
+// knownNodeOSes and knownNodeArches are the common values of the
+// kubernetes.io/os and kubernetes.io/arch node labels, which other values of
+// --node-os/--node-arch are warned about (as they're likely typos).
+var (
+	knownNodeOSes   = sets.New("linux", "windows")
+	knownNodeArches = sets.New("amd64", "arm64", "arm", "386", "ppc64le", "s390x")
+)
+
+// unknownValues returns the values that are not in the known set.
+func unknownValues(values []string, known sets.Set[string]) []string {
+	var out []string
+	for _, v := range values {
+		if !known.Has(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// nodeSelectorShortcut returns a node selector for the well-known instance
+// type, zone, OS and architecture labels (nil if none are specified).
+// Multiple values for a label are matched with the "in" operator.
+func nodeSelectorShortcut(instanceTypes, zones, oses, arches []string) (labels.Selector, error) {
+	sel := labels.NewSelector()
+	for _, v := range []struct {
+		key    string
+		values []string
+	}{
+		{corev1.LabelInstanceTypeStable, instanceTypes},
+		{corev1.LabelTopologyZone, zones},
+		{corev1.LabelOSStable, oses},
+		{corev1.LabelArchStable, arches},
+	} {
+		if len(v.values) == 0 {
+			continue
+		}
+		op := selection.In
+		if len(v.values) == 1 {
+			op = selection.Equals
+		}
+		req, err := labels.NewRequirement(v.key, op, v.values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for label %q: %w", v.key, err)
+		}
+		sel = sel.Add(*req)
+	}
+	if sel.Empty() {
+		return nil, nil
+	}
+	return sel, nil
+}
+
+// parsePodConditions parses the --condition values of the form type=status
+// (e.g. PodScheduled=False). The status is one of True, False or Unknown
+// (case-insensitive).
+func parsePodConditions(values []string) ([]corev1.PodCondition, error) {
+	var out []corev1.PodCondition
+	for _, v := range values {
+		typ, status, ok := strings.Cut(v, "=")
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("invalid condition %q (expected <type>=<status>, e.g. PodScheduled=False)", v)
+		}
+		var s corev1.ConditionStatus
+		switch strings.ToLower(status) {
+		case "true":
+			s = corev1.ConditionTrue
+		case "false":
+			s = corev1.ConditionFalse
+		case "unknown":
+			s = corev1.ConditionUnknown
+		default:
+			return nil, fmt.Errorf("invalid status %q in condition %q (expected True, False or Unknown)", status, v)
+		}
+		out = append(out, corev1.PodCondition{Type: corev1.PodConditionType(typ), Status: s})
+	}
+	return out, nil
+}
+
+// validateKubeconfigSelection checks that the given context and cluster names
+// (if specified) exist in the kubeconfig, and returns an error listing the
+// available names otherwise.
+func validateKubeconfigSelection(cfg clientcmdapi.Config, contextName, clusterName string) error {
+	if contextName != "" {
+		if _, ok := cfg.Contexts[contextName]; !ok {
+			return fmt.Errorf("context %q not found in kubeconfig (available contexts: %s)",
+				contextName, strings.Join(sets.List(sets.KeySet(cfg.Contexts)), ", "))
+		}
+	}
+	if clusterName != "" {
+		if _, ok := cfg.Clusters[clusterName]; !ok {
+			return fmt.Errorf("cluster %q not found in kubeconfig (available clusters: %s)",
+				clusterName, strings.Join(sets.List(sets.KeySet(cfg.Clusters)), ", "))
+		}
+	}
+	return nil
+}
+
+// toRESTConfig returns the REST config from the kubeconfig, and falls back to
+// the in-cluster config if there's no kubeconfig (or --server flag) and the
+// program is running in a pod.
+func toRESTConfig(kubeCfgFlags *genericclioptions.ConfigFlags, rawKubeCfg clientcmdapi.Config) (*rest.Config, error) {
+	if !clientcmdapi.IsConfigEmpty(&rawKubeCfg) || ptr.Deref(kubeCfgFlags.APIServer, "") != "" {
+		klog.V(2).Info("using REST config from kubeconfig")
+		return kubeCfgFlags.ToRESTConfig()
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return nil, errors.New("no kubeconfig found, and not running in a cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	klog.V(2).Info("no kubeconfig found, using in-cluster REST config")
+	restCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	return applyConfigOverrides(restCfg, configOverrides(kubeCfgFlags))
+}
+
+// applyConfigOverrides returns the in-cluster REST config with the overrides
+// applied the same way as to a kubeconfig (e.g. --request-timeout, --token,
+// --insecure-skip-tls-verify and the impersonation flags).
+func applyConfigOverrides(inCluster *rest.Config, overrides *clientcmd.ConfigOverrides) (*rest.Config, error) {
+	authInfo := &clientcmdapi.AuthInfo{Token: inCluster.BearerToken, TokenFile: inCluster.BearerTokenFile}
+	if overrides.AuthInfo.Token != "" {
+		// the token file would be used instead of the token
+		authInfo = &clientcmdapi.AuthInfo{}
+	}
+	const name = "in-cluster"
+	kubeCfg := clientcmdapi.Config{
+		Clusters:       map[string]*clientcmdapi.Cluster{name: {Server: inCluster.Host, CertificateAuthority: inCluster.TLSClientConfig.CAFile}},
+		AuthInfos:      map[string]*clientcmdapi.AuthInfo{name: authInfo},
+		Contexts:       map[string]*clientcmdapi.Context{name: {Cluster: name, AuthInfo: name}},
+		CurrentContext: name,
+	}
+	restCfg, err := clientcmd.NewDefaultClientConfig(kubeCfg, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply the flags to the in-cluster config: %w", err)
+	}
+	return restCfg, nil
+}
+
+// configOverrides returns the kubeconfig overrides set with the kubectl flags,
+// the same ones that ConfigFlags applies to the kubeconfig it loads.
+func configOverrides(f *genericclioptions.ConfigFlags) *clientcmd.ConfigOverrides {
+	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
+	overrides.AuthInfo.ClientCertificate = ptr.Deref(f.CertFile, "")
+	overrides.AuthInfo.ClientKey = ptr.Deref(f.KeyFile, "")
+	overrides.AuthInfo.Token = ptr.Deref(f.BearerToken, "")
+	overrides.AuthInfo.Impersonate = ptr.Deref(f.Impersonate, "")
+	overrides.AuthInfo.ImpersonateUID = ptr.Deref(f.ImpersonateUID, "")
+	overrides.AuthInfo.ImpersonateGroups = ptr.Deref(f.ImpersonateGroup, nil)
+	overrides.AuthInfo.Username = ptr.Deref(f.Username, "")
+	overrides.AuthInfo.Password = ptr.Deref(f.Password, "")
+	overrides.ClusterInfo.Server = ptr.Deref(f.APIServer, "")
+	overrides.ClusterInfo.TLSServerName = ptr.Deref(f.TLSServerName, "")
+	overrides.ClusterInfo.CertificateAuthority = ptr.Deref(f.CAFile, "")
+	overrides.ClusterInfo.InsecureSkipTLSVerify = ptr.Deref(f.Insecure, false)
+	overrides.ClusterInfo.DisableCompression = ptr.Deref(f.DisableCompression, false)
+	overrides.CurrentContext = ptr.Deref(f.Context, "")
+	overrides.Context.Cluster = ptr.Deref(f.ClusterName, "")
+	overrides.Context.AuthInfo = ptr.Deref(f.AuthInfoName, "")
+	overrides.Context.Namespace = ptr.Deref(f.Namespace, "")
+	overrides.Timeout = ptr.Deref(f.Timeout, "")
+	return overrides
+}
+
+// kubectlFlags returns the shell-quoted kubectl flags that select the same
+// kubeconfig, context, cluster and impersonated user as the given flags, for
+// the commands printed with -o delete-commands. The context defaults to the
+// current context, so the commands don't depend on it being switched later.
+func kubectlFlags(f *genericclioptions.ConfigFlags, currentContext string) []string {
+	var out []string
+	add := func(name, value string) {
+		if value != "" {
+			out = append(out, "--"+name+"="+shellQuote(value))
+		}
+	}
+	add("kubeconfig", ptr.Deref(f.KubeConfig, ""))
+	if ctx := ptr.Deref(f.Context, ""); ctx != "" {
+		currentContext = ctx
+	}
+	add("context", currentContext)
+	add("cluster", ptr.Deref(f.ClusterName, ""))
+	add("as", ptr.Deref(f.Impersonate, ""))
+	add("as-uid", ptr.Deref(f.ImpersonateUID, ""))
+	for _, group := range ptr.Deref(f.ImpersonateGroup, nil) {
+		add("as-group", group)
+	}
+	return out
+}
@@ -1,34 +1,70 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 )
 
 func TestParsePosArgs(t *testing.T) {
 	t.Run("no args", func(t *testing.T) {
-		_, _, err := parsePosArgs([]string{})
+		_, _, err := parsePosArgs([]string{}, posArgsAuto)
 		require.Error(t, err)
 	})
 	t.Run("node names only", func(t *testing.T) {
-		selectors, nodeNames, err := parsePosArgs([]string{"node1", "node2"})
+		selectors, nodeNames, err := parsePosArgs([]string{"node1", "node2"}, posArgsAuto)
 		require.NoError(t, err)
 		require.Empty(t, selectors)
 		require.ElementsMatch(t, []string{"node1", "node2"}, nodeNames)
 	})
+	t.Run("comma-separated node names", func(t *testing.T) {
+		selectors, nodeNames, err := parsePosArgs([]string{"node1,node2", "node3,"}, posArgsAuto)
+		require.NoError(t, err)
+		require.Empty(t, selectors)
+		require.ElementsMatch(t, []string{"node1", "node2", "node3"}, nodeNames)
+	})
 	t.Run("selectors only", func(t *testing.T) {
 		selectors, nodeNames, err := parsePosArgs([]string{
 			"foo=bar",
 			"baz!=qux",
 			"tier in (web,worker)",
-		})
+			"zone in(a,b)",
+		}, posArgsAuto)
+		require.NoError(t, err)
+		require.Empty(t, nodeNames)
+		require.Len(t, selectors, 4)
+	})
+	t.Run("negated selectors", func(t *testing.T) {
+		selectors, nodeNames, err := parsePosArgs([]string{"spot!=true", "spot notin (true)", "!spot"}, posArgsAuto)
 		require.NoError(t, err)
 		require.Empty(t, nodeNames)
 		require.Len(t, selectors, 3)
+		require.Equal(t, "!spot", selectors[2].String())
 	})
 	t.Run("selector parse error", func(t *testing.T) {
-		_, _, err := parsePosArgs([]string{"x in "})
+		_, _, err := parsePosArgs([]string{"x in "}, posArgsAuto)
 		require.Error(t, err)
 	})
 	t.Run("mixed node names and selectors", func(t *testing.T) {
@@ -37,9 +73,321 @@ func TestParsePosArgs(t *testing.T) {
 			"foo=bar",
 			"node2",
 			"baz!=qux",
-		})
+		}, posArgsAuto)
 		require.NoError(t, err)
 		require.ElementsMatch(t, []string{"node1", "node2"}, nodeNames)
 		require.Len(t, selectors, 2)
 	})
+	t.Run("nodes-only mode", func(t *testing.T) {
+		selectors, nodeNames, err := parsePosArgs([]string{"pool=a", "node1,node2"}, posArgsNodesOnly)
+		require.NoError(t, err)
+		require.Empty(t, selectors)
+		require.Equal(t, []string{"pool=a", "node1", "node2"}, nodeNames)
+	})
+	t.Run("selectors-only mode", func(t *testing.T) {
+		selectors, nodeNames, err := parsePosArgs([]string{"gpu", "pool=a"}, posArgsSelectorsOnly)
+		require.NoError(t, err)
+		require.Empty(t, nodeNames)
+		require.Len(t, selectors, 2)
+		require.Equal(t, "gpu", selectors[0].String())
+	})
+	t.Run("all-nodes mode", func(t *testing.T) {
+		selectors, nodeNames, err := parsePosArgs(nil, posArgsAllNodes)
+		require.NoError(t, err)
+		require.Empty(t, nodeNames)
+		require.Len(t, selectors, 1)
+		require.True(t, selectors[0].Empty(), "selects all nodes")
+
+		_, _, err = parsePosArgs([]string{"node1"}, posArgsAllNodes)
+		require.Error(t, err)
+	})
+}
+
+func TestParseSelectorsFile(t *testing.T) {
+	selectors, err := parseSelectorsFile(strings.NewReader(`# canonical pools
+pool=general
+
+  tier in (db, cache)
+!spot
+`))
+	require.NoError(t, err)
+	require.Len(t, selectors, 3)
+	require.Equal(t, "pool=general", selectors[0].String())
+	require.Equal(t, "tier in (cache,db)", selectors[1].String())
+	require.Equal(t, "!spot", selectors[2].String())
+
+	selectors, err = parseSelectorsFile(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, selectors)
+
+	_, err = parseSelectorsFile(strings.NewReader("pool=general\n\ntier in (db\n"))
+	require.ErrorContains(t, err, `line 3: failed to parse node selector "tier in (db"`)
+}
+
+func TestValidateKubeconfigSelection(t *testing.T) {
+	cfg := clientcmdapi.Config{
+		Contexts: map[string]*clientcmdapi.Context{"prod": {}, "dev": {}},
+		Clusters: map[string]*clientcmdapi.Cluster{"prod-cluster": {}},
+	}
+	require.NoError(t, validateKubeconfigSelection(cfg, "", ""))
+	require.NoError(t, validateKubeconfigSelection(cfg, "dev", "prod-cluster"))
+
+	err := validateKubeconfigSelection(cfg, "staging", "")
+	require.EqualError(t, err, `context "staging" not found in kubeconfig (available contexts: dev, prod)`)
+
+	err = validateKubeconfigSelection(cfg, "", "foo")
+	require.EqualError(t, err, `cluster "foo" not found in kubeconfig (available clusters: prod-cluster)`)
+}
+
+func TestApplyConfigOverridesInCluster(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile, caFile := filepath.Join(dir, "token"), filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("sa-token"), 0o600))
+	require.NoError(t, os.WriteFile(caFile, []byte("ca"), 0o600))
+	inCluster := &rest.Config{
+		Host:            "https://10.0.0.1:443",
+		BearerToken:     "sa-token",
+		BearerTokenFile: tokenFile,
+		TLSClientConfig: rest.TLSClientConfig{CAFile: caFile},
+	}
+
+	// without flags, the in-cluster config is kept
+	restCfg, err := applyConfigOverrides(inCluster, configOverrides(genericclioptions.NewConfigFlags(false)))
+	require.NoError(t, err)
+	require.Equal(t, "https://10.0.0.1:443", restCfg.Host)
+	require.Equal(t, "sa-token", restCfg.BearerToken)
+	require.Equal(t, tokenFile, restCfg.BearerTokenFile)
+	require.Equal(t, caFile, restCfg.CAFile)
+	require.Zero(t, restCfg.Timeout)
+
+	flags := genericclioptions.NewConfigFlags(false)
+	flags.Timeout = ptr.To("30s")
+	flags.BearerToken = ptr.To("user-token")
+	flags.Insecure = ptr.To(true)
+	flags.Impersonate = ptr.To("jane")
+	restCfg, err = applyConfigOverrides(inCluster, configOverrides(flags))
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, restCfg.Timeout)
+	require.Equal(t, "user-token", restCfg.BearerToken)
+	require.Empty(t, restCfg.BearerTokenFile, "the token file would take precedence over --token")
+	require.True(t, restCfg.Insecure)
+	require.Empty(t, restCfg.CAFile)
+	require.Equal(t, "jane", restCfg.Impersonate.UserName)
+
+	flags = genericclioptions.NewConfigFlags(false)
+	flags.Timeout = ptr.To("soon")
+	_, err = applyConfigOverrides(inCluster, configOverrides(flags))
+	require.Error(t, err)
+}
+
+func TestNodeSelectorShortcut(t *testing.T) {
+	sel, err := nodeSelectorShortcut(nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, sel)
+
+	sel, err = nodeSelectorShortcut([]string{"m5.large"}, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "node.kubernetes.io/instance-type=m5.large", sel.String())
+
+	sel, err = nodeSelectorShortcut([]string{"m5.large", "m5.xlarge"}, []string{"us-west-2a", "us-west-2b"}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "node.kubernetes.io/instance-type in (m5.large,m5.xlarge),topology.kubernetes.io/zone in (us-west-2a,us-west-2b)", sel.String())
+	require.True(t, sel.Matches(labels.Set{
+		"node.kubernetes.io/instance-type": "m5.xlarge",
+		"topology.kubernetes.io/zone":      "us-west-2a",
+	}))
+	require.False(t, sel.Matches(labels.Set{"node.kubernetes.io/instance-type": "m5.xlarge"}))
+
+	_, err = nodeSelectorShortcut(nil, []string{"not a valid value"}, nil, nil)
+	require.Error(t, err)
+
+	sel, err = nodeSelectorShortcut(nil, nil, []string{"linux"}, []string{"amd64", "arm64"})
+	require.NoError(t, err)
+	require.Equal(t, "kubernetes.io/arch in (amd64,arm64),kubernetes.io/os=linux", sel.String())
+	require.True(t, sel.Matches(labels.Set{"kubernetes.io/os": "linux", "kubernetes.io/arch": "arm64"}))
+	require.False(t, sel.Matches(labels.Set{"kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64"}))
+
+	require.Equal(t, []string{"darwin"}, unknownValues([]string{"linux", "darwin"}, knownNodeOSes))
+	require.Empty(t, unknownValues([]string{"arm64", "amd64"}, knownNodeArches))
+}
+
+func TestParseNodeFieldSelector(t *testing.T) {
+	sel, err := parseNodeFieldSelector("spec.unschedulable=false,metadata.name!=node1")
+	require.NoError(t, err)
+	require.Equal(t, "metadata.name!=node1,spec.unschedulable=false", sel.String())
+
+	_, err = parseNodeFieldSelector("spec.providerID=aws:///i-123")
+	require.ErrorContains(t, err, `field "spec.providerID" is not supported for nodes (supported fields: metadata.name, spec.unschedulable)`)
+
+	_, err = parseNodeFieldSelector("spec.unschedulable")
+	require.Error(t, err)
+}
+
+func TestQuietFlag(t *testing.T) {
+	t.Cleanup(klog.ClearLogger)
+
+	flagSet := pflag.NewFlagSet("", pflag.ContinueOnError)
+	applyLogFlags := addKlogFlags(flagSet)
+	require.NoError(t, flagSet.Parse([]string{"-v=4"}))
+	quiet, err := applyLogFlags()
+	require.NoError(t, err)
+	require.False(t, quiet)
+	require.True(t, bool(klog.V(4).Enabled()))
+
+	flagSet = pflag.NewFlagSet("", pflag.ContinueOnError)
+	applyLogFlags = addKlogFlags(flagSet)
+	require.NoError(t, flagSet.Parse([]string{"-v=4", "-q"}))
+	quiet, err = applyLogFlags()
+	require.NoError(t, err)
+	require.True(t, quiet)
+	require.Equal(t, "0", flagSet.Lookup("v").Value.String())
+	require.False(t, bool(klog.V(1).Enabled()))
+
+	flagSet = pflag.NewFlagSet("", pflag.ContinueOnError)
+	applyLogFlags = addKlogFlags(flagSet)
+	require.NoError(t, flagSet.Parse([]string{"--log-format=xml"}))
+	_, err = applyLogFlags()
+	require.ErrorContains(t, err, `invalid --log-format value "xml"`)
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	t.Cleanup(klog.ClearLogger)
+
+	klogFlagSet := flag.NewFlagSet("", flag.ContinueOnError)
+	klog.InitFlags(klogFlagSet)
+	var b bytes.Buffer
+	require.NoError(t, setKlogOutput(klogFlagSet, &b, logFormatJSON, false))
+	klog.Warningf("no nodes matched the given selectors (%d nodes listed)", 3)
+
+	var entry map[string]string
+	require.NoError(t, json.Unmarshal(b.Bytes(), &entry))
+	require.Equal(t, "warning", entry["level"])
+	require.Equal(t, "no nodes matched the given selectors (3 nodes listed)", entry["msg"])
+	require.Regexp(t, `^cli_test.go:\d+$`, entry["caller"])
+	require.NotEmpty(t, entry["ts"])
+}
+
+func TestKlogWriter(t *testing.T) {
+	lines := []string{
+		"I1017 10:00:00.000000       1 main.go:1] info\n",
+		"W1017 10:00:00.000000       1 main.go:2] warning\n",
+		"E1017 10:00:00.000000       1 main.go:3] error\n",
+		"F1017 10:00:00.000000       1 main.go:4] fatal\n",
+		"",
+	}
+	write := func(format string, errorsOnly bool) string {
+		var b bytes.Buffer
+		w := klogWriter(&b, format, errorsOnly)
+		for _, line := range lines {
+			w([]byte(line))
+		}
+		return b.String()
+	}
+
+	require.Equal(t, strings.Join(lines, ""), write(logFormatText, false))
+	require.Equal(t, lines[2]+lines[3], write(logFormatText, true))
+
+	out := write(logFormatJSON, true)
+	var levels, msgs, callers []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var entry map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		levels, msgs, callers = append(levels, entry["level"]), append(msgs, entry["msg"]), append(callers, entry["caller"])
+	}
+	require.Equal(t, []string{"error", "fatal"}, levels)
+	require.Equal(t, []string{"error", "fatal"}, msgs)
+	require.Equal(t, []string{"main.go:3", "main.go:4"}, callers)
+}
+
+func TestParsePodConditions(t *testing.T) {
+	conds, err := parsePodConditions([]string{"PodScheduled=False", "Ready=true", "ContainersReady=UNKNOWN"})
+	require.NoError(t, err)
+	require.Equal(t, []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionFalse},
+		{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		{Type: corev1.ContainersReady, Status: corev1.ConditionUnknown},
+	}, conds)
+
+	_, err = parsePodConditions([]string{"Ready"})
+	require.EqualError(t, err, `invalid condition "Ready" (expected <type>=<status>, e.g. PodScheduled=False)`)
+	_, err = parsePodConditions([]string{"Ready=yes"})
+	require.EqualError(t, err, `invalid status "yes" in condition "Ready=yes" (expected True, False or Unknown)`)
+}
+
+func TestToRESTConfigImpersonation(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers = make(map[string]http.Header) // request path -> headers
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		kind := "NodeList"
+		if strings.HasSuffix(r.URL.Path, "/pods") {
+			kind = "PodList"
+		}
+		w.Write([]byte(`{"kind":"` + kind + `","apiVersion":"v1","items":[]}`))
+	}))
+	defer srv.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, clientcmd.WriteToFile(clientcmdapi.Config{
+		Clusters:       map[string]*clientcmdapi.Cluster{"c": {Server: srv.URL}},
+		AuthInfos:      map[string]*clientcmdapi.AuthInfo{"u": {Token: "t"}},
+		Contexts:       map[string]*clientcmdapi.Context{"ctx": {Cluster: "c", AuthInfo: "u"}},
+		CurrentContext: "ctx",
+	}, kubeconfig))
+	flags := genericclioptions.NewConfigFlags(false)
+	flags.KubeConfig = ptr.To(kubeconfig)
+	flags.Impersonate = ptr.To("jane")
+	flags.ImpersonateUID = ptr.To("1234")
+	flags.ImpersonateGroup = ptr.To([]string{"devs", "oncall"})
+	rawKubeCfg, err := flags.ToRawKubeConfigLoader().RawConfig()
+	require.NoError(t, err)
+
+	restCfg, err := toRESTConfig(flags, rawKubeCfg)
+	require.NoError(t, err)
+	require.Equal(t, "jane", restCfg.Impersonate.UserName)
+	require.Equal(t, "1234", restCfg.Impersonate.UID)
+	require.Equal(t, []string{"devs", "oncall"}, restCfg.Impersonate.Groups)
+
+	// the nodes are queried with the clientset, and the pods with a separate
+	// REST client built from a copy of the config
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	require.NoError(t, err)
+	_, err = clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	podsClient, err := makePodsRESTClient(func() (*rest.Config, error) { return rest.CopyConfig(restCfg), nil })
+	require.NoError(t, err)
+	require.NoError(t, podsClient.Get().Resource("pods").Do(context.Background()).Error())
+
+	for _, path := range []string{"/api/v1/nodes", "/api/v1/pods"} {
+		require.Contains(t, headers, path)
+		require.Equal(t, "jane", headers[path].Get("Impersonate-User"), path)
+		require.Equal(t, "1234", headers[path].Get("Impersonate-Uid"), path)
+		require.Equal(t, []string{"devs", "oncall"}, headers[path].Values("Impersonate-Group"), path)
+	}
+}
+
+func TestKubectlFlags(t *testing.T) {
+	// the current context is pinned unless --context is given
+	flags := genericclioptions.NewConfigFlags(false)
+	require.Equal(t, []string{"--context=prod"}, kubectlFlags(flags, "prod"))
+	require.Empty(t, kubectlFlags(flags, ""), "in-cluster")
+
+	flags.KubeConfig = ptr.To("/home/me/.kube/other config")
+	flags.Context = ptr.To("staging")
+	flags.ClusterName = ptr.To("east")
+	flags.Impersonate = ptr.To("jane")
+	flags.ImpersonateGroup = ptr.To([]string{"devs", "on call"})
+	require.Equal(t, []string{
+		"--kubeconfig='/home/me/.kube/other config'",
+		"--context=staging",
+		"--cluster=east",
+		"--as=jane",
+		"--as-group=devs",
+		"--as-group='on call'",
+	}, kubectlFlags(flags, "prod"))
 }
@@ -0,0 +1,180 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// completionTimeout bounds listing the nodes so that an unreachable
+	// cluster doesn't hang the shell.
+	completionTimeout = 3 * time.Second
+
+	// completionCacheTTL is how long the listed nodes are reused across
+	// completions (each completion runs a new process).
+	completionCacheTTL = 30 * time.Second
+)
+
+// completionNode is the part of a node needed for completions, as cached on
+// disk.
+type completionNode struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// completeArgs completes the positional arguments with the node names, or
+// with node label values for the selector arguments (key=...). Errors (e.g. an
+// unreachable cluster) result in no completions.
+func completeArgs(ctx context.Context, flagSet *pflag.FlagSet, kubeCfgFlags *genericclioptions.ConfigFlags, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// complete the nodes of the cluster queried with the same arguments, e.g.
+	// the --context set in the config file or the in-cluster config in a pod
+	if err := applyConfigDefaults(flagSet, os.Getenv); err != nil {
+		klog.V(2).Infof("completion: failed to apply the config defaults: %v", err)
+	}
+	rawKubeCfg, err := kubeCfgFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		klog.V(2).Infof("completion: failed to load kubeconfig: %v", err)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	restCfg, err := toRESTConfig(kubeCfgFlags, rawKubeCfg)
+	if err != nil {
+		klog.V(2).Infof("completion: failed to get REST config: %v", err)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	restCfg.Timeout = completionTimeout
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		klog.V(2).Infof("completion: failed to create clientset: %v", err)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cacheFile, err := completionCacheFile(restCfg.Host)
+	if err != nil {
+		klog.V(2).Infof("completion: not caching nodes: %v", err)
+	}
+	nodes, err := listCompletionNodes(ctx, clientset.CoreV1().Nodes(), cacheFile, time.Now())
+	if err != nil {
+		klog.V(2).Infof("completion: failed to list nodes: %v", err)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nodeCompletions(nodes, args, toComplete)
+}
+
+// completionCacheFile returns the path of the cached nodes of the API server
+// with the given host.
+func completionCacheFile(host string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kubectl-pods_on", fmt.Sprintf("nodes-%x.json", sha256.Sum256([]byte(host)))), nil
+}
+
+// listCompletionNodes lists the nodes, reusing the ones cached in cacheFile
+// if they're listed in the last completionCacheTTL. An empty cacheFile
+// disables caching.
+func listCompletionNodes(ctx context.Context, nodes corev1client.NodeInterface, cacheFile string, now time.Time) ([]completionNode, error) {
+	if cacheFile != "" {
+		if fi, err := os.Stat(cacheFile); err == nil && now.Sub(fi.ModTime()) < completionCacheTTL {
+			b, err := os.ReadFile(cacheFile)
+			if err == nil {
+				var out []completionNode
+				if err := json.Unmarshal(b, &out); err == nil {
+					return out, nil
+				}
+			}
+			klog.V(2).Infof("completion: ignoring unreadable cache file %s", cacheFile)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	list, err := nodes.List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]completionNode, 0, len(list.Items))
+	for _, n := range list.Items {
+		out = append(out, completionNode{Name: n.Name, Labels: n.Labels})
+	}
+
+	if cacheFile != "" {
+		if err := writeCompletionCache(cacheFile, out); err != nil {
+			klog.V(2).Infof("completion: failed to cache nodes: %v", err)
+		}
+	}
+	return out, nil
+}
+
+func writeCompletionCache(cacheFile string, nodes []completionNode) error {
+	b, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(cacheFile, b, 0o600)
+}
+
+// nodeCompletions returns the completions of toComplete: the values of the
+// node label if it's a selector (key=...), otherwise the node names that
+// aren't already in args, and the node label keys (as key=) once a prefix is
+// typed.
+func nodeCompletions(nodes []completionNode, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if key, valuePrefix, ok := strings.Cut(toComplete, "="); ok {
+		values := sets.New[string]()
+		for _, n := range nodes {
+			if v, ok := n.Labels[key]; ok && strings.HasPrefix(v, valuePrefix) {
+				values.Insert(key + "=" + v)
+			}
+		}
+		return sets.List(values), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	specified := sets.New(args...)
+	names, keys := sets.New[string](), sets.New[string]()
+	for _, n := range nodes {
+		if strings.HasPrefix(n.Name, toComplete) && !specified.Has(n.Name) {
+			names.Insert(n.Name)
+		}
+		for k := range n.Labels {
+			if toComplete != "" && strings.HasPrefix(k, toComplete) {
+				keys.Insert(k + "=")
+			}
+		}
+	}
+	if names.Len() == 0 && keys.Len() > 0 {
+		// don't add a space after key= so that the value can be completed
+		return sets.List(keys), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+	return append(sets.List(names), sets.List(keys)...), cobra.ShellCompDirectiveNoFileComp
+}
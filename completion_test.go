@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNodeCompletions(t *testing.T) {
+	nodes := []completionNode{
+		{Name: "pool-a-2", Labels: map[string]string{"pool": "a", "topology.kubernetes.io/zone": "us-west-1a"}},
+		{Name: "pool-a-1", Labels: map[string]string{"pool": "a", "topology.kubernetes.io/zone": "us-west-1b"}},
+		{Name: "pool-b-1", Labels: map[string]string{"pool": "b", "topology.kubernetes.io/zone": "us-west-1a"}},
+	}
+	tests := []struct {
+		name       string
+		args       []string
+		toComplete string
+		want       []string
+		directive  cobra.ShellCompDirective
+	}{
+		{"all names", nil, "", []string{"pool-a-1", "pool-a-2", "pool-b-1"}, cobra.ShellCompDirectiveNoFileComp},
+		{"names and keys by prefix", nil, "pool", []string{"pool-a-1", "pool-a-2", "pool-b-1", "pool="}, cobra.ShellCompDirectiveNoFileComp},
+		{"specified names excluded", []string{"pool-a-1"}, "pool-a", []string{"pool-a-2"}, cobra.ShellCompDirectiveNoFileComp},
+		{"only keys", nil, "topo", []string{"topology.kubernetes.io/zone="}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace},
+		{"values", nil, "pool=", []string{"pool=a", "pool=b"}, cobra.ShellCompDirectiveNoFileComp},
+		{"values by prefix", nil, "topology.kubernetes.io/zone=us-west-1b", []string{"topology.kubernetes.io/zone=us-west-1b"}, cobra.ShellCompDirectiveNoFileComp},
+		{"unknown key", nil, "foo=", []string{}, cobra.ShellCompDirectiveNoFileComp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, directive := nodeCompletions(nodes, tt.args, tt.toComplete)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.directive, directive)
+		})
+	}
+}
+
+func TestListCompletionNodes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"pool": "a"}}},
+	)
+	cacheFile := filepath.Join(t.TempDir(), "kubectl-pods_on", "nodes.json")
+
+	nodes, err := listCompletionNodes(ctx, client.CoreV1().Nodes(), cacheFile, now)
+	require.NoError(t, err)
+	require.Equal(t, []completionNode{{Name: "node1", Labels: map[string]string{"pool": "a"}}}, nodes)
+	require.Equal(t, 1, countActions(client, "list"))
+
+	t.Run("cached", func(t *testing.T) {
+		cached, err := listCompletionNodes(ctx, client.CoreV1().Nodes(), cacheFile, now.Add(completionCacheTTL/2))
+		require.NoError(t, err)
+		require.Equal(t, nodes, cached)
+		require.Equal(t, 1, countActions(client, "list"))
+	})
+	t.Run("cache expired", func(t *testing.T) {
+		_, err := listCompletionNodes(ctx, client.CoreV1().Nodes(), cacheFile, now.Add(2*completionCacheTTL))
+		require.NoError(t, err)
+		require.Equal(t, 2, countActions(client, "list"))
+	})
+	t.Run("unreachable cluster", func(t *testing.T) {
+		require.NoError(t, os.Remove(cacheFile))
+		client.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("connection refused")
+		})
+		_, err := listCompletionNodes(ctx, client.CoreV1().Nodes(), cacheFile, now)
+		require.Error(t, err)
+		_, err = os.Stat(cacheFile)
+		require.True(t, os.IsNotExist(err), "failed list should not be cached")
+	})
+}
+
+func TestCompleteArgsConfigDefaults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/nodes", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(corev1.NodeList{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "NodeList"},
+			Items:    []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}},
+		}))
+	}))
+	t.Cleanup(srv.Close)
+
+	// the cluster is only set in the config file
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte("server: "+srv.URL+"\n"), 0o600))
+	t.Setenv(configFileEnv, cfgFile)
+	t.Setenv("KUBECONFIG", filepath.Join(dir, "kubeconfig"))
+	t.Setenv("XDG_CACHE_HOME", dir)
+
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	kubeCfgFlags := addConfigFlags(fs)
+	require.NoError(t, fs.Parse(nil))
+	got, directive := completeArgs(context.Background(), fs, kubeCfgFlags, nil, "")
+	require.Equal(t, []string{"node1"}, got)
+	require.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+}
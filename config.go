@@ -0,0 +1,173 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// configFileEnv is the environment variable to override the path of the
+// config file.
+const configFileEnv = "PODS_ON_CONFIG"
+
+// flagEnvVars are the environment variables that set the default values of
+// the flags (by flag name), e.g. for CI where flags can't always be passed.
+var flagEnvVars = map[string]string{
+	"workers":  "PODS_ON_WORKERS",
+	"strategy": "PODS_ON_STRATEGY",
+	"timeout":  "PODS_ON_TIMEOUT",
+}
+
+// envFlagDefaults returns the default flag values set in the environment
+// variables (looked up with getenv).
+func envFlagDefaults(getenv func(string) string) map[string]string {
+	out := make(map[string]string)
+	for name, env := range flagEnvVars {
+		if v := getenv(env); v != "" {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// configFilePath returns the path of the config file, which is
+// $XDG_CONFIG_HOME/kubectl-pods_on/config.yaml (~/.config if not set) unless
+// overridden with PODS_ON_CONFIG.
+func configFilePath() (string, error) {
+	if p := os.Getenv(configFileEnv); p != "" {
+		return p, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kubectl-pods_on", "config.yaml"), nil
+}
+
+// applyConfigDefaults sets the flags that weren't specified on the command
+// line to the environment variables (looked up with getenv), then the config
+// file values. The config file is skipped if its path can't be determined,
+// e.g. $HOME is usually not set in a pod.
+func applyConfigDefaults(fs *pflag.FlagSet, getenv func(string) string) error {
+	cfgPath, err := configFilePath()
+	if err != nil {
+		klog.V(1).Infof("skipping the config file: %v", err)
+		return applyFlagDefaults(fs, envFlagDefaults(getenv))
+	}
+	cfg, err := loadConfigFile(cfgPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config file: %w", err)
+	}
+	if err := applyFlagDefaults(fs, cfg, envFlagDefaults(getenv)); err != nil {
+		return fmt.Errorf("failed to apply flag defaults (%s or environment variables): %w", cfgPath, err)
+	}
+	return nil
+}
+
+// loadConfigFile reads the default flag values from the YAML config file at
+// path, which maps flag names to values (lists are used for slice flags).
+// A missing config file is not an error.
+func loadConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	out := make(map[string]string, len(raw))
+	for name, v := range raw {
+		s, err := configValueString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q in %s: %w", name, path, err)
+		}
+		out[name] = s
+	}
+	return out, nil
+}
+
+// configValueString converts a YAML value to its flag value representation.
+func configValueString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// applyFlagDefaults sets the flags that weren't specified on the command line
+// to the values in defaults. Later defaults take precedence over the earlier
+// ones.
+func applyFlagDefaults(fs *pflag.FlagSet, defaults ...map[string]string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *pflag.Flag) { explicit[f.Name] = true })
+
+	for _, values := range defaults {
+		for name, v := range values {
+			f := fs.Lookup(name)
+			if f == nil {
+				return fmt.Errorf("unknown flag %q", name)
+			}
+			if explicit[name] {
+				continue
+			}
+			var err error
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				var items []string
+				if v != "" {
+					items = strings.Split(v, ",")
+				}
+				err = sv.Replace(items)
+			} else {
+				err = f.Value.Set(v)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid value %q for flag %q: %w", v, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := loadConfigFile(filepath.Join(dir, "missing.yaml"))
+	require.NoError(t, err)
+	require.Empty(t, cfg)
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+workers: 50
+qps: 1.5
+strategy: by-node
+include-daemonsets: true
+node-label-columns: [topology.kubernetes.io/zone, pool]
+max-pods: 1000000
+`), 0o644))
+	cfg, err = loadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"workers":            "50",
+		"qps":                "1.5",
+		"strategy":           "by-node",
+		"include-daemonsets": "true",
+		"node-label-columns": "topology.kubernetes.io/zone,pool",
+		"max-pods":           "1000000",
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(path, []byte("workers: {a: 1}"), 0o644))
+	_, err = loadConfigFile(path)
+	require.ErrorContains(t, err, `"workers"`)
+}
+
+func TestApplyFlagDefaults(t *testing.T) {
+	newFlags := func(args ...string) (*pflag.FlagSet, *int64, *string, *[]string) {
+		fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+		workers := fs.Int64("workers", 20, "")
+		strategy := fs.String("strategy", "", "")
+		columns := fs.StringSlice("node-label-columns", nil, "")
+		require.NoError(t, fs.Parse(args))
+		return fs, workers, strategy, columns
+	}
+
+	t.Run("built-in defaults", func(t *testing.T) {
+		fs, workers, strategy, columns := newFlags()
+		require.NoError(t, applyFlagDefaults(fs))
+		require.EqualValues(t, 20, *workers)
+		require.Empty(t, *strategy)
+		require.Empty(t, *columns)
+	})
+	t.Run("config file", func(t *testing.T) {
+		fs, workers, strategy, columns := newFlags()
+		require.NoError(t, applyFlagDefaults(fs, map[string]string{"workers": "50", "strategy": "all-pods", "node-label-columns": "a,b"}))
+		require.EqualValues(t, 50, *workers)
+		require.Equal(t, "all-pods", *strategy)
+		require.Equal(t, []string{"a", "b"}, *columns)
+	})
+	t.Run("later defaults take precedence", func(t *testing.T) {
+		fs, workers, _, columns := newFlags()
+		require.NoError(t, applyFlagDefaults(fs,
+			map[string]string{"workers": "50", "node-label-columns": "a,b"},
+			map[string]string{"workers": "60", "node-label-columns": "c"}))
+		require.EqualValues(t, 60, *workers)
+		require.Equal(t, []string{"c"}, *columns)
+	})
+	t.Run("command line takes precedence", func(t *testing.T) {
+		fs, workers, strategy, columns := newFlags("--workers=5", "--node-label-columns=x")
+		require.NoError(t, applyFlagDefaults(fs, map[string]string{"workers": "50", "strategy": "by-node", "node-label-columns": "a,b"}))
+		require.EqualValues(t, 5, *workers)
+		require.Equal(t, "by-node", *strategy)
+		require.Equal(t, []string{"x"}, *columns)
+	})
+	t.Run("errors", func(t *testing.T) {
+		fs, _, _, _ := newFlags()
+		require.ErrorContains(t, applyFlagDefaults(fs, map[string]string{"no-such-flag": "1"}), `unknown flag "no-such-flag"`)
+		require.ErrorContains(t, applyFlagDefaults(fs, map[string]string{"workers": "many"}), `invalid value "many" for flag "workers"`)
+	})
+}
+
+func TestEnvFlagDefaults(t *testing.T) {
+	env := map[string]string{"PODS_ON_WORKERS": "50", "PODS_ON_TIMEOUT": "30s", "OTHER": "x"}
+	defaults := envFlagDefaults(func(k string) string { return env[k] })
+	require.Equal(t, map[string]string{"workers": "50", "timeout": "30s"}, defaults)
+
+	newFlags := func(args ...string) (*pflag.FlagSet, *int64, *time.Duration) {
+		fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+		workers := fs.Int64("workers", 20, "")
+		fs.String("strategy", "", "")
+		timeout := fs.Duration("timeout", 5*time.Minute, "")
+		addConfigFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		return fs, workers, timeout
+	}
+
+	t.Run("neither", func(t *testing.T) {
+		fs, workers, timeout := newFlags()
+		require.NoError(t, applyFlagDefaults(fs, envFlagDefaults(func(string) string { return "" })))
+		require.EqualValues(t, 20, *workers)
+		require.Equal(t, 5*time.Minute, *timeout)
+	})
+	t.Run("only env set", func(t *testing.T) {
+		fs, workers, timeout := newFlags()
+		require.NoError(t, applyFlagDefaults(fs, map[string]string{"workers": "40"}, defaults))
+		require.EqualValues(t, 50, *workers, "env should take precedence over the config file")
+		require.Equal(t, 30*time.Second, *timeout)
+	})
+	t.Run("flag set", func(t *testing.T) {
+		fs, workers, timeout := newFlags("--workers=5", "--timeout=1m")
+		require.NoError(t, applyFlagDefaults(fs, defaults))
+		require.EqualValues(t, 5, *workers)
+		require.Equal(t, time.Minute, *timeout)
+	})
+	t.Run("invalid env value", func(t *testing.T) {
+		fs, _, _ := newFlags()
+		err := applyFlagDefaults(fs, envFlagDefaults(func(k string) string {
+			return map[string]string{"PODS_ON_WORKERS": "lots"}[k]
+		}))
+		require.ErrorContains(t, err, `invalid value "lots" for flag "workers"`)
+
+		// durations are parsed like the --timeout flag
+		fs, _, _ = newFlags()
+		err = applyFlagDefaults(fs, envFlagDefaults(func(k string) string {
+			return map[string]string{"PODS_ON_TIMEOUT": "soon"}[k]
+		}))
+		require.ErrorContains(t, err, `invalid value "soon" for flag "timeout"`)
+	})
+}
+
+func TestApplyConfigDefaultsWithoutHome(t *testing.T) {
+	// in a pod, $HOME is usually not set
+	t.Setenv(configFileEnv, "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "")
+	_, err := configFilePath()
+	require.Error(t, err)
+
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	workers := fs.Int64("workers", 20, "")
+	require.NoError(t, applyConfigDefaults(fs, func(k string) string {
+		return map[string]string{"PODS_ON_WORKERS": "50"}[k]
+	}))
+	require.EqualValues(t, 50, *workers, "the environment variables are still applied")
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// stage is the phase of the program an error occurred in.
+type stage string
+
+const (
+	stageInit         stage = "init"
+	stageResolveNodes stage = "resolve-nodes"
+	stageQuery        stage = "query"
+	stagePrint        stage = "print"
+)
+
+// exitCodes are the exit codes used for errors in each stage when errors are
+// printed in json format.
+var exitCodes = map[stage]int{
+	stageInit:         2,
+	stageResolveNodes: 3,
+	stageQuery:        4,
+	stagePrint:        5,
+}
+
+// stageError is an error with the stage it occurred in, for the errors of
+// functions that span several stages.
+type stageError struct {
+	stage stage
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// stageErrorf formats an error like fmt.Errorf, as occurred in stage s.
+func stageErrorf(s stage, format string, args ...any) error {
+	return &stageError{stage: s, err: fmt.Errorf(format, args...)}
+}
+
+// errorStage returns the stage err occurred in, or def if it doesn't carry
+// one.
+func errorStage(err error, def stage) stage {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.stage
+	}
+	return def
+}
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// errorHandler terminates the program on fatal errors, reporting the error
+// either as a log message (text format) or as a JSON object on stderr with a
+// distinct exit code per stage (json format).
+type errorHandler struct {
+	format string
+}
+
+func (h errorHandler) fatalf(s stage, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if h.format != errorFormatJSON {
+		klog.FatalDepth(1, msg)
+	}
+	_ = json.NewEncoder(os.Stderr).Encode(struct {
+		Error string `json:"error"`
+		Stage stage  `json:"stage"`
+	}{msg, s})
+	klog.Flush()
+	os.Exit(exitCodes[s])
+}
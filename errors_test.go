@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorHandlerJSON(t *testing.T) {
+	if os.Getenv("TEST_ERROR_HANDLER_JSON") == "1" {
+		errorHandler{format: errorFormatJSON}.fatalf(stageResolveNodes, "nodes not found in the cluster: %s", "node1")
+		return
+	}
+	// fatalf exits the process, so run it in a subprocess
+	cmd := exec.Command(os.Args[0], "-test.run=^TestErrorHandlerJSON$")
+	cmd.Env = append(os.Environ(), "TEST_ERROR_HANDLER_JSON=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, exitCodes[stageResolveNodes], exitErr.ExitCode())
+	require.JSONEq(t, `{"error":"nodes not found in the cluster: node1","stage":"resolve-nodes"}`, stderr.String())
+}
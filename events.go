@@ -0,0 +1,106 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/semgroup"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// maxEventMessageLen is the maximum length of the event shown in the table.
+const maxEventMessageLen = 60
+
+// listPodEvents lists the events involving pods in the given namespaces. The
+// namespaces the user isn't allowed to list events in are skipped and
+// returned in forbidden (sorted) instead of failing.
+func listPodEvents(ctx context.Context, client typedcorev1.EventsGetter, namespaces []string, numWorkers int64) (events []corev1.Event, forbidden []string, err error) {
+	var mu sync.Mutex
+	g := semgroup.NewGroup(ctx, numWorkers)
+	for _, n := range namespaces {
+		ns := n
+		g.Go(func() error {
+			list, err := client.Events(ns).List(ctx, metav1.ListOptions{FieldSelector: "involvedObject.kind=Pod"})
+			if apierrors.IsForbidden(err) {
+				klog.V(2).Infof("forbidden to list events in namespace %q: %v", ns, err)
+				mu.Lock()
+				forbidden = append(forbidden, ns)
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to list events in namespace %q: %w", ns, err)
+			}
+			mu.Lock()
+			events = append(events, list.Items...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	err = g.Wait()
+	sort.Strings(forbidden)
+	return events, forbidden, err
+}
+
+// lastPodEvents returns the most recent event of each pod (by pod UID) as a
+// "Reason: message" string truncated for display.
+func lastPodEvents(events []corev1.Event) map[types.UID]string {
+	latest := make(map[types.UID]corev1.Event)
+	for _, ev := range events {
+		uid := ev.InvolvedObject.UID
+		if cur, ok := latest[uid]; !ok || eventTime(ev).After(eventTime(cur)) {
+			latest[uid] = ev
+		}
+	}
+	out := make(map[types.UID]string, len(latest))
+	for uid, ev := range latest {
+		out[uid] = formatEvent(ev)
+	}
+	return out
+}
+
+// eventTime returns the time the event was last observed.
+func eventTime(ev corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	default:
+		return ev.CreationTimestamp.Time
+	}
+}
+
+func formatEvent(ev corev1.Event) string {
+	msg := ev.Reason
+	if ev.Message != "" {
+		msg += ": " + strings.Join(strings.Fields(ev.Message), " ")
+	}
+	if r := []rune(msg); len(r) > maxEventMessageLen {
+		msg = string(r[:maxEventMessageLen-3]) + "..."
+	}
+	return msg
+}
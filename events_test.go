@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestListPodEventsForbidden(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "ev1", Namespace: "ns1"}},
+		&corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "ev2", Namespace: "ns2"}},
+	)
+	client.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if ns := action.GetNamespace(); ns == "ns2" || ns == "ns3" {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("events"), "", nil)
+		}
+		return false, nil, nil
+	})
+
+	events, forbidden, err := listPodEvents(context.Background(), client.CoreV1(), []string{"ns3", "ns1", "ns2"}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ns2", "ns3"}, forbidden)
+	require.Len(t, events, 1)
+	require.Equal(t, "ev1", events[0].Name)
+}
+
+func TestLastPodEvents(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	event := func(uid, reason, msg string, ago time.Duration) corev1.Event {
+		return corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", UID: types.UID(uid)},
+			Reason:         reason,
+			Message:        msg,
+			LastTimestamp:  metav1.NewTime(now.Add(-ago)),
+		}
+	}
+	out := lastPodEvents([]corev1.Event{
+		event("pod1", "Scheduled", "Successfully assigned", 10*time.Minute),
+		event("pod1", "BackOff", "Back-off restarting\nfailed container", time.Minute),
+		event("pod1", "Pulled", "Container image already present", 5*time.Minute),
+		event("pod2", "FailedScheduling", strings.Repeat("x", 100), time.Minute),
+		{ // event with only eventTime set
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", UID: "pod3"},
+			Reason:         "Started",
+			EventTime:      metav1.NewMicroTime(now),
+		},
+	})
+	require.Equal(t, map[types.UID]string{
+		"pod1": "BackOff: Back-off restarting failed container",
+		"pod2": "FailedScheduling: " + strings.Repeat("x", 39) + "...",
+		"pod3": "Started",
+	}, out)
+	require.Len(t, out["pod2"], maxEventMessageLen)
+}
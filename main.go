@@ -16,43 +16,164 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	_ "net/http/pprof"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"reflect"
+	goruntime "runtime"
 	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/spf13/pflag"
+	"github.com/fatih/semgroup"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
-	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/pager"
 	"k8s.io/klog/v2"
+	kubectlget "k8s.io/kubectl/pkg/cmd/get"
 	"k8s.io/kubectl/pkg/scheme"
 	"k8s.io/utils/ptr"
 )
 
+// options are the parsed flags of the command.
+type options struct {
+	applyLogFlags   func() (quiet bool, err error)
+	kubeConfigFlags *genericclioptions.ConfigFlags
+	printFlags      *kubectlget.PrintFlags
+
+	includeDaemonSets         bool
+	contexts                  []string
+	numWorkers                int64
+	batchNodes                int
+	strictNodes               bool
+	fromWorkload              string
+	instanceTypes             []string
+	zones                     []string
+	nodeOSes                  []string
+	nodeArches                []string
+	nodeFieldSelector         string
+	maxRetries                int
+	qps                       float32
+	burst                     int
+	userAgent                 string
+	pprofAddr                 string
+	pprofWait                 bool
+	strategy                  string
+	includeUnscheduled        bool
+	useCache                  bool
+	resourceVersion           string
+	noProgress                bool
+	showStats                 bool
+	verboseTimings            bool
+	metrics                   bool
+	waitFor                   string
+	waitTimeout               time.Duration
+	pollInterval              time.Duration
+	dryRun                    bool
+	explain                   bool
+	outputFile                string
+	alsoOutputJSON            string
+	alsoOutputYAML            string
+	colorMode                 string
+	owner                     string
+	ownerKind                 string
+	image                     string
+	includeEphemeral          bool
+	since                     time.Duration
+	olderThan                 time.Duration
+	excludeSucceededOlderThan time.Duration
+	podSelector               string
+	excludeSelector           string
+	conditions                []string
+	notReady                  bool
+	probeFailures             bool
+	maxPods                   int
+	totals                    bool
+	fullOutput                bool
+	summary                   bool
+	summaryOnly               bool
+	topNodes                  int
+	countBy                   string
+	compact                   bool
+	groupBy                   string
+	listNodes                 bool
+	invert                    bool
+	showScheduling            bool
+	showContainers            bool
+	showInitContainers        bool
+	showUID                   bool
+	fullUID                   bool
+	showEvents                bool
+	sortDescending            bool
+	sortByNodePressure        bool
+	highlightRecentRestarts   bool
+	recentRestartWindow       time.Duration
+	showMatchedContainer      bool
+	showNodeTaints            bool
+	showNodeStatus            bool
+	nodeLabelColumns          []string
+	topologyColumns           bool
+	errorFormat               string
+	nodeCapacity              bool
+	nodesOnly                 bool
+	selectorsOnly             bool
+	selectorsFromFile         string
+	allNodes                  bool
+	totalNodesHint            int
+	nodeColumn                string
+	truncate                  int
+	onlyNotReadyNodes         bool
+	shortNames                bool
+	columns                   []string
+	deleteCommandPrefix       string
+	noEnhance                 bool
+	groupJSONByNode           bool
+	serveAddr                 string
+	printVersion              bool
+}
+
 func main() {
 	ctx := context.Background()
 
 	// Set up flags
-	flagSet := pflag.NewFlagSet("", pflag.ExitOnError)
-	flagSet.Usage = func() {
+	cmd := &cobra.Command{
+		Use:               "kubectl-pods_on",
+		Annotations:       map[string]string{cobra.CommandDisplayNameAnnotation: "kubectl pods-on"},
+		Args:              cobra.ArbitraryArgs,
+		CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
+		SilenceErrors:     true,
+		SilenceUsage:      true,
+	}
+	flagSet := cmd.Flags()
+	usage := func() {
 		fmt.Fprintln(os.Stderr, `Usage:
-	kubectl pods-on [flags] [node name or selector...]
+	kubectl pods-on [flags] [node name, internal IP or selector...]
 
 Examples:
 	kubectl pods-on node1.example.com node2.example.com
+	kubectl pods-on 10.0.0.12 fd00::12
 	kubectl pods-on node-label=foo
 	kubectl pods-on "nodelabel in (value1, value2)"
+	kubectl pods-on 'nodelabel!=foo' '!nodelabel'
+	kubectl pods-on node-label=foo -o custom-columns=NODE,NAMESPACE,NAME,IP:.status.podIP
+
+Custom columns:
+	NODE, NAMESPACE and NAME columns can be used in -o custom-columns without
+	a field spec, and are expanded to .spec.nodeName, .metadata.namespace and
+	.metadata.name respectively.
 
 Caveats:
 	If this command runs slow on large clusters for you, it's probably because
@@ -61,109 +182,690 @@ Caveats:
 	manually tune the query strategy with --workers/--strategy flags.
 
 Options:`)
-		flagSet.PrintDefaults()
+		fmt.Fprint(os.Stderr, flagSet.FlagUsages())
 	}
+	cmd.SetHelpFunc(func(*cobra.Command, []string) { usage() })
+	cmd.SetUsageFunc(func(*cobra.Command) error { usage(); return nil })
 
 	utilruntime.Must(metav1.AddMetaToScheme(scheme.Scheme))
 
 	// Add kubectl flags
-	addKlogFlags(flagSet)
-	kubeConfigFlags := addConfigFlags(flagSet)
-	printFlags := addPrintFlags(flagSet)
+	opts := &options{
+		applyLogFlags:   addKlogFlags(flagSet),
+		kubeConfigFlags: addConfigFlags(flagSet),
+		printFlags:      addPrintFlags(flagSet),
+	}
 	// Add custom flags
-	includeDaemonSets := flagSet.BoolP("include-daemonsets", "D", false, "Include DaemonSet Pods in the output")
-	numWorkers := flagSet.Int64("workers", 20, "number of parallel workers to query pods by node")
-	pprofAddr := flagSet.String("pprof-addr", "", "(dev mode) inspect the program with pprof on the given address at the end")
-	strategy := flagSet.String("strategy", "", "(dev mode) choose a strategy to query pods (by-node, all-pods)")
-	flagSet.Parse(os.Args[1:])
-
-	// Start pprof server if configured
-	if *pprofAddr != "" {
-		klog.Infof("starting pprof server at %s", *pprofAddr)
-		go func() {
-			if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
-				klog.Warning("failed to start pprof server: ", err)
+	flagSet.BoolVarP(&opts.includeDaemonSets, "include-daemonsets", "D", false, "Include DaemonSet Pods in the output")
+	flagSet.StringSliceVar(&opts.contexts, "contexts", nil, "query the pods in each of the given kubeconfig contexts concurrently, and merge the results with a Context column")
+	flagSet.Int64Var(&opts.numWorkers, "workers", 20, "number of parallel workers to query pods by node (or set "+flagEnvVars["workers"]+")")
+	flagSet.IntVar(&opts.batchNodes, "batch-nodes", 0, "query pods by node in batches of this many nodes, starting a batch after the previous one completes (0: no batching)")
+	flagSet.BoolVar(&opts.strictNodes, "strict-nodes", false, "fail if any of the node names specified don't exist in the cluster")
+	flagSet.StringVar(&opts.fromWorkload, "from-workload", "", "select the nodes the workload's pods can be scheduled on by its nodeSelector and required node affinity (e.g. deployment/my-app, in the current namespace)")
+	flagSet.StringSliceVar(&opts.instanceTypes, "instance-type", nil, "select nodes with the given instance types ("+corev1.LabelInstanceTypeStable+" label)")
+	flagSet.StringSliceVar(&opts.zones, "zone", nil, "select nodes in the given zones ("+corev1.LabelTopologyZone+" label)")
+	flagSet.StringSliceVar(&opts.nodeOSes, "node-os", nil, "select nodes with the given operating systems, e.g. linux, windows ("+corev1.LabelOSStable+" label)")
+	flagSet.StringSliceVar(&opts.nodeArches, "node-arch", nil, "select nodes with the given architectures, e.g. amd64, arm64 ("+corev1.LabelArchStable+" label)")
+	flagSet.StringVar(&opts.nodeFieldSelector, "node-field-selector", "", "field selector to select nodes on the server side, combined with the node selectors (e.g. spec.unschedulable=false)")
+	flagSet.IntVar(&opts.maxRetries, "max-retries", 3, "number of times to retry API calls on transient errors (throttling, timeouts, network errors)")
+	flagSet.Float32Var(&opts.qps, "qps", 0, "client-side QPS limit for API requests (default: 3x --workers)")
+	flagSet.IntVar(&opts.burst, "burst", 0, "client-side burst limit for API requests (default: 3x --qps)")
+	flagSet.StringVar(&opts.userAgent, "user-agent", "", "User-Agent header to send to the API server, e.g. to attribute the calls in audit logs (default: "+defaultUserAgent()+")")
+	flagSet.StringVar(&opts.pprofAddr, "pprof-addr", "", "(dev mode) inspect the program with pprof on the given address at the end (or set "+pprofAddrEnv+")")
+	flagSet.BoolVar(&opts.pprofWait, "pprof-wait", false, "(dev mode) keep the program alive at the end for pprof inspection")
+	flagSet.StringVar(&opts.strategy, "strategy", "", "(dev mode) choose a strategy to query pods (by-node, all-pods) (or set "+flagEnvVars["strategy"]+")")
+	flagSet.BoolVar(&opts.includeUnscheduled, "include-unscheduled", false, "include pods that are not scheduled to a node yet (implies --strategy=all-pods)")
+	flagSet.BoolVar(&opts.useCache, "use-cache", false, "list pods from the apiserver watch cache (faster, but results may be slightly stale)")
+	flagSet.StringVar(&opts.resourceVersion, "resource-version", "", "list pods at the given resource version for a consistent snapshot across the queries (fails if the version is compacted by the API server)")
+	flagSet.BoolVar(&opts.noProgress, "no-progress", false, "don't show the progress of querying pods by node (only shown when stderr is a terminal)")
+	flagSet.BoolVar(&opts.showStats, "stats", false, "print a summary of the query (strategy, pages, pods retrieved/matched, duration) to stderr")
+	flagSet.BoolVar(&opts.verboseTimings, "verbose-timings", false, "print how long each phase took (listing nodes, matching selectors, querying, filtering and printing pods) to stderr")
+	flagSet.BoolVar(&opts.metrics, "metrics", false, "print metrics of the run (query duration, pods matched, API pages) to stderr in the Prometheus text format")
+	flagSet.StringVar(&opts.waitFor, "wait-for", "", "query the pods again every --poll-interval until all matched pods are in the given phase (e.g. Running), and fail after --timeout")
+	flagSet.DurationVar(&opts.waitTimeout, "timeout", 5*time.Minute, "how long to wait for the pods with --wait-for")
+	flagSet.DurationVar(&opts.pollInterval, "poll-interval", 5*time.Second, "how often to query the pods with --wait-for")
+	flagSet.BoolVar(&opts.dryRun, "dry-run", false, "print the query plan (strategy, matched nodes, selectors) without querying pods")
+	flagSet.BoolVar(&opts.explain, "explain", false, "explain why the pod query strategy was chosen (matched/total nodes, threshold) on stderr")
+	flagSet.StringVar(&opts.outputFile, "output-file", "", "write the output to the given file instead of stdout (logs and progress are still printed to stderr)")
+	flagSet.StringVar(&opts.alsoOutputJSON, "also-output-json", "", "also write the pods as a json PodList to the given file, in addition to the output")
+	flagSet.StringVar(&opts.alsoOutputYAML, "also-output-yaml", "", "also write the pods as a yaml PodList to the given file, in addition to the output")
+	flagSet.StringVar(&opts.colorMode, "color", colorAuto, "colorize pod status in table output (auto, always, never)")
+	flagSet.StringVar(&opts.owner, "owner", "", "only show pods owned by the workload with the given name (Deployments are matched via their ReplicaSets' names)")
+	flagSet.StringVar(&opts.ownerKind, "owner-kind", "", "kind of the workload specified with --owner (e.g. Deployment, StatefulSet)")
+	flagSet.StringVar(&opts.image, "image", "", "only show pods with a container (or init container) image containing the given string")
+	flagSet.BoolVar(&opts.includeEphemeral, "include-ephemeral", false, "consider ephemeral (debug) containers in --image matching")
+	flagSet.DurationVar(&opts.since, "since", 0, "only show pods created within the given duration (e.g. 1h, 30m)")
+	flagSet.DurationVar(&opts.olderThan, "older-than", 0, "only show pods created more than the given duration ago (e.g. 24h)")
+	flagSet.DurationVar(&opts.excludeSucceededOlderThan, "exclude-succeeded-older-than", 0, "hide the Succeeded pods that completed (or were created, if unknown) more than the given duration ago (e.g. 1h)")
+	flagSet.StringVarP(&opts.podSelector, "selector", "l", "", "only show pods whose labels match the selector (e.g. app=web)")
+	flagSet.StringVar(&opts.excludeSelector, "exclude-selector", "", "hide the pods whose labels match the selector (e.g. app=fluentd)")
+	flagSet.StringSliceVar(&opts.conditions, "condition", nil, "only show pods with the given condition (type=status, e.g. PodScheduled=False), can be repeated to match all of them")
+	flagSet.BoolVar(&opts.notReady, "not-ready", false, "only show pods that are not Running or have a container that is not ready")
+	flagSet.BoolVar(&opts.probeFailures, "probe-failures", false, "only show pods likely failing their liveness or readiness probes (a heuristic based on the container statuses)")
+	flagSet.IntVar(&opts.maxPods, "max-pods", 0, "maximum number of pods to print (0 for unlimited)")
+	flagSet.BoolVar(&opts.totals, "totals", false, "print the total number of pods and nodes after the table output")
+	flagSet.BoolVar(&opts.fullOutput, "full-output", false, "keep noisy metadata fields (managedFields, last-applied-configuration annotation) in non-table output formats")
+	flagSet.BoolVar(&opts.summary, "summary", false, "print the number of pods on each node after the pods (in json, yaml or jsonl formats, print only the summary)")
+	flagSet.BoolVar(&opts.summaryOnly, "summary-only", false, "print only the number of pods on each node (as an array in json/yaml formats, or one object per line in jsonl)")
+	flagSet.IntVar(&opts.topNodes, "top-nodes", 0, "print only the given number of nodes with the most matched pods, and their pod counts (as an array in json/yaml formats)")
+	flagSet.StringVar(&opts.countBy, "count-by", "node", "group the pod counts of --summary/--summary-only by node, namespace, phase or owner-kind")
+	flagSet.BoolVar(&opts.compact, "compact", false, "print each node (or namespace with --group-by=namespace) on a line with the pods in it (comma-separated)")
+	flagSet.StringVar(&opts.groupBy, "group-by", "node", "sort the pods by node or namespace first (node, namespace), also groups the lines of --compact")
+	flagSet.BoolVar(&opts.listNodes, "list-nodes", false, "print only the sorted names of the nodes hosting the matched pods (one per line)")
+	flagSet.BoolVar(&opts.invert, "invert", false, "with --list-nodes, print the matched nodes hosting none of the matched pods instead")
+	flagSet.BoolVar(&opts.showScheduling, "show-scheduling", false, "show the scheduler name and non-default tolerations of each pod as columns in table output")
+	flagSet.BoolVar(&opts.showContainers, "show-containers", false, "show the container names of each pod as a column in table output")
+	flagSet.BoolVar(&opts.showInitContainers, "show-init-containers", false, "include the init containers (prefixed with init:) in --show-containers (implies --show-containers)")
+	flagSet.BoolVar(&opts.showUID, "show-uid", false, "show the pod UID (its first 8 characters) as a column in table output, e.g. to tell apart recreated pods")
+	flagSet.BoolVar(&opts.fullUID, "full-uid", false, "show the full pod UID with --show-uid (implies --show-uid)")
+	flagSet.BoolVar(&opts.showEvents, "show-events", false, "show the most recent event of each pod as a column in table output")
+	flagSet.BoolVarP(&opts.sortDescending, "sort-descending", "r", false, "list the pods in reverse order (by node, namespace and name descending)")
+	flagSet.BoolVar(&opts.sortByNodePressure, "sort-by-node-pressure", false, "list the pods on nodes with memory, disk or PID pressure first")
+	flagSet.BoolVar(&opts.highlightRecentRestarts, "highlight-recent-restarts", false, "highlight the pods with a container restarted within --recent-restart-window in table output (marked with * after the name without color)")
+	flagSet.DurationVar(&opts.recentRestartWindow, "recent-restart-window", 5*time.Minute, "how recent a container restart is highlighted by --highlight-recent-restarts")
+	flagSet.BoolVar(&opts.showMatchedContainer, "show-matched-container", false, "show the names of the containers matching --image as a column in table output (init containers are prefixed with init:)")
+	flagSet.BoolVar(&opts.showNodeTaints, "show-node-taints", false, "show the taints of the pod's node as a column in table output")
+	flagSet.BoolVar(&opts.showNodeStatus, "show-node-status", false, "show the Ready condition of the pod's node as a column in table output")
+	flagSet.StringSliceVar(&opts.nodeLabelColumns, "node-label-columns", nil, "comma-separated list of node labels to show as columns in table output")
+	flagSet.BoolVar(&opts.topologyColumns, "topology-columns", false, "show the zone and region of the pod's node (from the topology.kubernetes.io labels) as columns in table output")
+	flagSet.StringVar(&opts.errorFormat, "error-format", errorFormatText, "format of fatal errors printed to stderr (text, json)")
+	flagSet.BoolVar(&opts.nodeCapacity, "node-capacity", false, "print the allocatable CPU/memory of each matched node and the sum of the requests of the matched pods on it instead of the pods")
+	flagSet.BoolVar(&opts.nodesOnly, "nodes-only", false, "treat all positional arguments as node names")
+	flagSet.BoolVar(&opts.selectorsOnly, "selectors-only", false, "treat all positional arguments as node label selectors")
+	flagSet.StringVar(&opts.selectorsFromFile, "selectors-from-file", "", "read node selectors from the file, one per line (blank lines and lines starting with # are skipped), OR'ed with the other selectors")
+	flagSet.BoolVar(&opts.allNodes, "all-nodes", false, "query the pods on all nodes, without specifying node names or selectors (uses the all-pods strategy)")
+	flagSet.IntVar(&opts.totalNodesHint, "total-nodes-hint", 0, "advanced: the number of nodes in the cluster to choose the query strategy with when nodes aren't listed (only node names are given)")
+	flagSet.StringVar(&opts.nodeColumn, "node-column", "first", "position of the Node and Namespace columns (and the node columns) in table output (first, last)")
+	flagSet.IntVar(&opts.truncate, "truncate", 0, "truncate the node, namespace and pod names in table output to the given number of characters (0 for no truncation)")
+	flagSet.BoolVar(&opts.onlyNotReadyNodes, "only-notready-nodes", false, "only query the matched nodes whose Ready condition is not True")
+	flagSet.BoolVar(&opts.shortNames, "short-names", false, "also match node names given as arguments against the first DNS label of the node names (e.g. node1 matches node1.example.com)")
+	flagSet.StringSliceVar(&opts.columns, "columns", nil, "comma-separated names of the only columns to print in table output, e.g. node,name,status (default all)")
+	flagSet.StringVar(&opts.deleteCommandPrefix, "delete-command-prefix", "kubectl", "the kubectl command in -o delete-commands output, followed by the --kubeconfig, --context, --cluster and --as flags of the query")
+	flagSet.BoolVar(&opts.noEnhance, "no-enhance", false, "print the pod table of the server as is (like kubectl get pods), without the Node and Namespace columns and the columns added by other flags")
+	flagSet.BoolVar(&opts.groupJSONByNode, "group-json-by-node", false, "in json/yaml output, print an object keyed by node name with the pods on each node instead of a v1/PodList (not a Kubernetes API type)")
+	flagSet.StringVar(&opts.serveAddr, "serve", "", "serve pod queries over HTTP on the given address (e.g. :8080) from a cache of all pods and nodes, instead of querying once")
+	flagSet.BoolVar(&opts.printVersion, "version", false, "print the version information and exit")
+	cmd.ValidArgsFunction = func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeArgs(ctx, flagSet, opts.kubeConfigFlags, args, toComplete)
+	}
+	cmd.Run = func(_ *cobra.Command, posArgs []string) {
+		if opts.printVersion {
+			fmt.Println(formatVersion(version, commit, date))
+			return
+		}
+
+		// Flags not specified on the command line default to the environment
+		// variables, then the config file values (errors before they're
+		// applied are reported in the --error-format on the command line)
+		fail := errorHandler{format: opts.errorFormat}
+		if err := applyConfigDefaults(flagSet, os.Getenv); err != nil {
+			fail.fatalf(stageInit, "%v", err)
+		}
+		if opts.errorFormat != errorFormatText && opts.errorFormat != errorFormatJSON {
+			fail.fatalf(stageInit, "invalid --error-format value %q (expected %s or %s)", opts.errorFormat, errorFormatText, errorFormatJSON)
+		}
+		fail = errorHandler{format: opts.errorFormat}
+		if err := run(ctx, opts, posArgs); err != nil {
+			fail.fatalf(errorStage(err, stageInit), "%v", err)
+		}
+	}
+	if err := cmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// run runs the query with the given options and positional arguments (the
+// config file and environment variable defaults already applied). The errors
+// carry the stage they occurred in.
+func run(ctx context.Context, opts *options, posArgs []string) error {
+	quiet, err := opts.applyLogFlags()
+	if err != nil {
+		return stageErrorf(stageInit, "failed to configure logging: %w", err)
+	}
+	if err := validateStrategy(podQueryStrategy(opts.strategy)); err != nil {
+		return stageErrorf(stageInit, "invalid --strategy: %w", err)
+	}
+	useColor, err := shouldColorize(opts.colorMode, opts.outputFile == "" && term.IsTerminal(int(os.Stdout.Fd())))
+	if err != nil {
+		return stageErrorf(stageInit, "failed to parse flags: %w", err)
+	}
+	var waitPhase corev1.PodPhase
+	if opts.waitFor != "" {
+		if waitPhase, err = parsePodPhase(opts.waitFor); err != nil {
+			return stageErrorf(stageInit, "invalid --wait-for: %w", err)
+		}
+		if opts.pollInterval <= 0 || opts.waitTimeout <= 0 {
+			return stageErrorf(stageInit, "--poll-interval and --timeout must be positive")
+		}
+	}
+	if _, ok := groupByCmps[opts.groupBy]; !ok {
+		return stageErrorf(stageInit, "invalid --group-by value %q (expected node or namespace)", opts.groupBy)
+	}
+	if _, ok := countByKeys[opts.countBy]; !ok {
+		return stageErrorf(stageInit, "invalid --count-by value %q (expected node, namespace, phase or owner-kind)", opts.countBy)
+	}
+	if err := validateResourceVersion(opts.resourceVersion, opts.useCache); err != nil {
+		return stageErrorf(stageInit, "invalid --resource-version: %w", err)
+	}
+	if len(opts.columns) > 0 && !isTableFormat(opts.printFlags) {
+		return stageErrorf(stageInit, "--columns can only be used with table output")
+	}
+	if opts.noEnhance && !isTableFormat(opts.printFlags) {
+		return stageErrorf(stageInit, "--no-enhance can only be used with table output")
+	}
+	if opts.groupJSONByNode && !isStructuredFormat(ptr.Deref(opts.printFlags.OutputFormat, "")) {
+		return stageErrorf(stageInit, "--group-json-by-node can only be used with -o json or -o yaml")
+	}
+	if opts.invert && !opts.listNodes {
+		return stageErrorf(stageInit, "--invert can only be used with --list-nodes")
+	}
+	if opts.invert && opts.maxPods > 0 {
+		// the nodes of the pods past --max-pods would be listed
+		return stageErrorf(stageInit, "--invert lists the nodes hosting none of the matched pods, and can't be used with --max-pods")
+	}
+	if opts.topNodes < 0 {
+		return stageErrorf(stageInit, "--top-nodes must not be negative")
+	}
+	if opts.maxPods < 0 {
+		return stageErrorf(stageInit, "--max-pods must not be negative")
+	}
+	if opts.maxPods > 0 && (opts.topNodes > 0 || opts.summary || opts.summaryOnly) {
+		// the pod counts would only include the first --max-pods pods
+		return stageErrorf(stageInit, "--top-nodes, --summary and --summary-only count all matched pods, and can't be used with --max-pods")
+	}
+	if (opts.topNodes > 0 || opts.summary || opts.summaryOnly) && !isSummaryFormat(ptr.Deref(opts.printFlags.OutputFormat, "")) {
+		return stageErrorf(stageInit, "--top-nodes, --summary and --summary-only print the pod counts as a table, json, yaml or jsonl, and can't be used with -o %s", ptr.Deref(opts.printFlags.OutputFormat, ""))
+	}
+	if opts.topNodes > 0 && opts.countBy != "node" {
+		return stageErrorf(stageInit, "--top-nodes counts pods by node, and can't be used with --count-by=%s", opts.countBy)
+	}
+	if opts.nodeColumn != "first" && opts.nodeColumn != "last" {
+		return stageErrorf(stageInit, "invalid --node-column value %q (expected first or last)", opts.nodeColumn)
+	}
+	if opts.truncate < 0 {
+		return stageErrorf(stageInit, "--truncate must not be negative")
+	}
+	if opts.totalNodesHint < 0 {
+		return stageErrorf(stageInit, "--total-nodes-hint must not be negative")
+	}
+	if opts.nodeCapacity && opts.maxPods > 0 {
+		return stageErrorf(stageInit, "--node-capacity sums the requests of all matched pods, and can't be used with --max-pods")
+	}
+	if opts.nodeCapacity && !isTableFormat(opts.printFlags) {
+		return stageErrorf(stageInit, "--node-capacity can only be used with table output")
+	}
+
+	if opts.serveAddr != "" && len(posArgs) > 0 {
+		return stageErrorf(stageInit, "--serve takes the node names and selectors of each query as the arg parameters of GET /pods, not as arguments")
+	}
+	if opts.serveAddr != "" && len(opts.contexts) > 1 {
+		return stageErrorf(stageInit, "--serve caches the pods of a single context, and can't be used with more than one of --contexts")
+	}
+	if opts.serveAddr != "" {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"zone", len(opts.zones) > 0},
+			{"instance-type", len(opts.instanceTypes) > 0},
+			{"node-os", len(opts.nodeOSes) > 0},
+			{"node-arch", len(opts.nodeArches) > 0},
+			{"selectors-from-file", opts.selectorsFromFile != ""},
+			{"node-field-selector", opts.nodeFieldSelector != ""},
+			{"from-workload", opts.fromWorkload != ""},
+			{"all-nodes", opts.allNodes},
+		} {
+			if f.set {
+				return stageErrorf(stageInit, "--%s can't be used with --serve, the nodes are selected with the arg parameters of each query", f.name)
 			}
-		}()
+		}
 	}
 
-	posArgs := flagSet.Args()
+	// Start pprof server if configured (--pprof-addr keeps the program alive
+	// at the end for backwards compatibility)
+	pprofDone := startPprof(opts.pprofAddr, opts.pprofWait || opts.pprofAddr != "")
+
 	klog.V(3).Info("positional arguments: ", posArgs)
-	selectors, nodeNames, err := parsePosArgs(posArgs)
+	var (
+		selectors []labels.Selector
+		nodeNames []string
+	)
+	if opts.nodeFieldSelector != "" {
+		if _, err := parseNodeFieldSelector(opts.nodeFieldSelector); err != nil {
+			return stageErrorf(stageInit, "failed to parse --node-field-selector: %w", err)
+		}
+	}
+	shortcutSelector, err := nodeSelectorShortcut(opts.instanceTypes, opts.zones, opts.nodeOSes, opts.nodeArches)
 	if err != nil {
-		klog.Fatalf("failed to parse arguments: %v", err)
+		return stageErrorf(stageInit, "failed to parse --instance-type/--zone/--node-os/--node-arch: %w", err)
+	}
+	if unknown := unknownValues(opts.nodeOSes, knownNodeOSes); len(unknown) > 0 {
+		klog.Warningf("unknown --node-os values: %s (known: %s)", strings.Join(unknown, ", "), strings.Join(sets.List(knownNodeOSes), ", "))
+	}
+	if unknown := unknownValues(opts.nodeArches, knownNodeArches); len(unknown) > 0 {
+		klog.Warningf("unknown --node-arch values: %s (known: %s)", strings.Join(unknown, ", "), strings.Join(sets.List(knownNodeArches), ", "))
+	}
+	var workloadKind, workloadName string
+	if opts.fromWorkload != "" {
+		workloadKind, workloadName, err = parseWorkloadRef(opts.fromWorkload)
+		if err != nil {
+			return stageErrorf(stageInit, "failed to parse --from-workload: %w", err)
+		}
+	}
+	if opts.nodesOnly && opts.selectorsOnly {
+		return stageErrorf(stageInit, "--nodes-only and --selectors-only can't be used together")
 	}
+	if opts.allNodes && (opts.nodesOnly || opts.selectorsOnly) {
+		return stageErrorf(stageInit, "--all-nodes can't be used with --nodes-only or --selectors-only")
+	}
+	if opts.allNodes && podQueryStrategy(opts.strategy) == queryPodPerNodeInParallel {
+		return stageErrorf(stageInit, "--all-nodes can't be used with the %q strategy", opts.strategy)
+	}
+	if opts.includeUnscheduled && podQueryStrategy(opts.strategy) == queryPodPerNodeInParallel {
+		// unscheduled pods can't be queried by node name
+		return stageErrorf(stageInit, "--include-unscheduled can't be used with the %q strategy", opts.strategy)
+	}
+	posArgsMode := posArgsAuto
+	if opts.nodesOnly {
+		posArgsMode = posArgsNodesOnly
+	} else if opts.selectorsOnly {
+		posArgsMode = posArgsSelectorsOnly
+	} else if opts.allNodes {
+		posArgsMode = posArgsAllNodes
+	}
+	if opts.serveAddr == "" && (len(posArgs) > 0 || opts.allNodes || (opts.nodeFieldSelector == "" && shortcutSelector == nil && opts.fromWorkload == "" && opts.selectorsFromFile == "")) {
+		selectors, nodeNames, err = parsePosArgs(posArgs, posArgsMode)
+		if err != nil {
+			return stageErrorf(stageInit, "failed to parse arguments: %w", err)
+		}
+	}
+	if shortcutSelector != nil {
+		selectors = append(selectors, shortcutSelector)
+	}
+	if opts.selectorsFromFile != "" {
+		f, err := os.Open(opts.selectorsFromFile)
+		if err != nil {
+			return stageErrorf(stageInit, "failed to open --selectors-from-file: %w", err)
+		}
+		fileSelectors, err := parseSelectorsFile(f)
+		f.Close()
+		if err != nil {
+			return stageErrorf(stageInit, "failed to parse %s: %w", opts.selectorsFromFile, err)
+		}
+		klog.V(1).Infof("read %d node selectors from %s", len(fileSelectors), opts.selectorsFromFile)
+		selectors = append(selectors, fileSelectors...)
+	}
+	if opts.nodeFieldSelector != "" && len(nodeNames) > 0 {
+		return stageErrorf(stageInit, "--node-field-selector cannot be used with node names (%v), use node selectors instead", nodeNames)
+	}
+	nodeNames, nodeIPs := splitNodeIPs(nodeNames)
 
-	restCfg, err := kubeConfigFlags.ToRESTConfig()
+	rawKubeCfg, err := opts.kubeConfigFlags.ToRawKubeConfigLoader().RawConfig()
 	if err != nil {
-		klog.Fatalf("failed to get REST config: %v", err)
+		return stageErrorf(stageInit, "failed to load kubeconfig: %w", err)
+	}
+	if err := validateKubeconfigSelection(rawKubeCfg, ptr.Deref(opts.kubeConfigFlags.Context, ""), ptr.Deref(opts.kubeConfigFlags.ClusterName, "")); err != nil {
+		return stageErrorf(stageInit, "%w", err)
 	}
-	restCfg.QPS = float32(*numWorkers) * 3
-	restCfg.Burst = int(restCfg.QPS) * 3
 
-	clientset, err := kubernetes.NewForConfig(restCfg)
+	if opts.showMatchedContainer && opts.image == "" {
+		return stageErrorf(stageInit, "--show-matched-container requires --image")
+	}
+	podConditions, err := parsePodConditions(opts.conditions)
 	if err != nil {
-		klog.Fatalf("failed to create clientset: %v", err)
+		return stageErrorf(stageInit, "failed to parse --condition: %w", err)
+	}
+	for _, c := range podConditions {
+		if c.Type == corev1.PodScheduled && c.Status != corev1.ConditionTrue && !opts.includeUnscheduled {
+			klog.Warningf("unscheduled pods are not queried, use --include-unscheduled to find the pods with %s=%s", c.Type, c.Status)
+		}
+	}
+	podLabels := labels.Everything()
+	if opts.podSelector != "" {
+		if podLabels, err = labels.Parse(opts.podSelector); err != nil {
+			return stageErrorf(stageInit, "invalid --selector: %w", err)
+		}
+	}
+	var excludeLabels labels.Selector
+	if opts.excludeSelector != "" {
+		if excludeLabels, err = labels.Parse(opts.excludeSelector); err != nil {
+			return stageErrorf(stageInit, "invalid --exclude-selector: %w", err)
+		}
+	}
+	filters := podFilters{
+		includeDaemonSets: opts.includeDaemonSets,
+		owner:             opts.owner,
+		ownerKind:         opts.ownerKind,
+		image:             opts.image,
+		includeEphemeral:  opts.includeEphemeral,
+		since:             opts.since,
+		olderThan:         opts.olderThan,
+		notReady:          opts.notReady,
+		probeFailures:     opts.probeFailures,
+		conditions:        podConditions,
+		succeededMaxAge:   opts.excludeSucceededOlderThan,
+		excludeLabels:     excludeLabels,
+	}
+
+	if len(opts.contexts) > 0 {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"context", ptr.Deref(opts.kubeConfigFlags.Context, "") != ""},
+			// resource versions and node counts are specific to a cluster
+			{"resource-version", opts.resourceVersion != ""},
+			{"total-nodes-hint", opts.totalNodesHint > 0},
+		} {
+			if f.set {
+				return stageErrorf(stageInit, "--%s can't be used with --contexts", f.name)
+			}
+		}
+		for _, name := range opts.contexts {
+			if err := validateKubeconfigSelection(rawKubeCfg, name, ""); err != nil {
+				return stageErrorf(stageInit, "invalid --contexts: %w", err)
+			}
+		}
+	}
+
+	tblOpts := tableOpts{
+		nodeLabelColumns: opts.nodeLabelColumns,
+		showNodeStatus:   opts.showNodeStatus,
+		showNodeTaints:   opts.showNodeTaints,
+		showLastEvent:    opts.showEvents,
+		showScheduling:   opts.showScheduling,
+		showContainers:   opts.showContainers || opts.showInitContainers,
+		initContainers:   opts.showInitContainers,
+		showUID:          opts.showUID || opts.fullUID,
+		fullUID:          opts.fullUID,
+		truncate:         opts.truncate,
+		nodeColumnsLast:  opts.nodeColumn == "last",
+		showTopology:     opts.topologyColumns,
+	}
+	pOpts := printOpts{
+		color:               useColor,
+		totals:              opts.totals,
+		listNodes:           opts.listNodes,
+		invert:              opts.invert,
+		compact:             opts.compact,
+		groupBy:             opts.groupBy,
+		fullOutput:          opts.fullOutput,
+		summary:             opts.summary,
+		summaryOnly:         opts.summaryOnly,
+		countBy:             opts.countBy,
+		topNodes:            opts.topNodes,
+		nodeCapacity:        opts.nodeCapacity,
+		columns:             opts.columns,
+		noEnhance:           opts.noEnhance,
+		groupByNode:         opts.groupJSONByNode,
+		tableOpts:           tblOpts,
+		deleteCommandPrefix: opts.deleteCommandPrefix,
+		deleteCommandFlags:  kubectlFlags(opts.kubeConfigFlags, rawKubeCfg.CurrentContext),
+	}
+	if len(opts.contexts) > 0 {
+		// the commands get the --context of each pod
+		pOpts.deleteCommandFlags = kubectlFlags(opts.kubeConfigFlags, "")
+	}
+
+	// The query runs in the current context, or in each of the --contexts
+	baseQuery := contextQuery{
+		selectors:          selectors,
+		nodeNames:          nodeNames,
+		nodeIPs:            nodeIPs,
+		nodeFieldSelector:  opts.nodeFieldSelector,
+		workloadKind:       workloadKind,
+		workloadName:       workloadName,
+		shortNames:         opts.shortNames,
+		strictNodes:        opts.strictNodes,
+		onlyNotReadyNodes:  opts.onlyNotReadyNodes,
+		allNodes:           opts.allNodes,
+		includeUnscheduled: opts.includeUnscheduled,
+		totalNodesHint:     opts.totalNodesHint,
+		strategy:           podQueryStrategy(opts.strategy),
+		numWorkers:         opts.numWorkers,
+		batchNodes:         opts.batchNodes,
+		// the progress bars of concurrent contexts would overwrite each other
+		showProgress: len(opts.contexts) == 0 && !opts.noProgress && !quiet && term.IsTerminal(int(os.Stderr.Fd())),
+		queryOpts: podQueryOpts{
+			namespace:       ptr.Deref(opts.kubeConfigFlags.Namespace, ""),
+			labelSelector:   podLabels.String(),
+			useWatchCache:   opts.useCache,
+			resourceVersion: opts.resourceVersion,
+			maxRetries:      opts.maxRetries,
+			// the pod tables only need the pods' metadata if the filters
+			// and the printed columns only read the metadata, and the pods
+			// aren't written elsewhere or waited on
+			metadataOnly: isTableFormat(opts.printFlags) && filters.metadataOnly() && pOpts.metadataOnly() &&
+				!opts.highlightRecentRestarts && opts.waitFor == "" && opts.alsoOutputJSON == "" && opts.alsoOutputYAML == "",
+		},
+	}
+	withRateLimits := func(restConfig func() (*rest.Config, error)) func() (*rest.Config, error) {
+		return func() (*rest.Config, error) {
+			restCfg, err := restConfig()
+			if err != nil {
+				return nil, err
+			}
+			setRateLimits(restCfg, opts.numWorkers, opts.qps, opts.burst)
+			restCfg.UserAgent = userAgentOrDefault(opts.userAgent)
+			return restCfg, nil
+		}
+	}
+	var queries []contextQuery
+	if len(opts.contexts) == 0 {
+		q := baseQuery
+		q.restConfig = withRateLimits(func() (*rest.Config, error) { return toRESTConfig(opts.kubeConfigFlags, rawKubeCfg) })
+		q.namespace = func() (string, error) {
+			namespace, _, err := opts.kubeConfigFlags.ToRawKubeConfigLoader().Namespace()
+			return namespace, err
+		}
+		queries = append(queries, q)
+	}
+	overrides := configOverrides(opts.kubeConfigFlags)
+	for _, name := range opts.contexts {
+		clientCfg := contextClientConfig(rawKubeCfg, name, overrides)
+		q := baseQuery
+		q.name = name
+		q.restConfig = withRateLimits(clientCfg.ClientConfig)
+		q.namespace = func() (string, error) {
+			namespace, _, err := clientCfg.Namespace()
+			return namespace, err
+		}
+		queries = append(queries, q)
 	}
 
-	var heuristicTotalNodes int
-	matchedNodes := sets.New[string](nodeNames...)
-	if len(selectors) > 0 {
-		klog.V(3).Info("resolving node selectors: ", selectors)
-		out, n, err := resolveNodeNames(ctx, clientset.CoreV1().Nodes(), selectors)
+	if opts.serveAddr != "" {
+		restCfg, err := queries[0].restConfig()
 		if err != nil {
-			klog.Fatalf("failed to resolve nodes by selectors: %v", err)
+			return stageErrorf(stageInit, "failed to get REST config: %w", err)
 		}
-		matchedNodes = matchedNodes.Union(out)
-		heuristicTotalNodes = n
+		clientset, err := kubernetes.NewForConfig(restCfg)
+		if err != nil {
+			return stageErrorf(stageInit, "failed to create clientset: %w", err)
+		}
+		serveCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := serve(serveCtx, opts.serveAddr, clientset, serveOpts{
+			namespace:          ptr.Deref(opts.kubeConfigFlags.Namespace, ""),
+			podLabels:          podLabels,
+			includeUnscheduled: opts.includeUnscheduled,
+			filters:            filters,
+		}); err != nil {
+			return stageErrorf(stageQuery, "failed to serve: %w", err)
+		}
+		pprofDone()
+		return nil
 	}
-	klog.V(3).Infof("total nodes to query: %d", matchedNodes.Len())
 
-	queryStrategy := podQueryStrategy(*strategy)
-	if queryStrategy == "" {
-		queryStrategy = chooseStrategy(heuristicTotalNodes, matchedNodes.Len())
-		klog.V(1).Infof("based on nodes matched to selectors (%d/%d), using query strategy: %q",
-			matchedNodes.Len(), heuristicTotalNodes, queryStrategy)
+	var timings phaseTimings
+	targets, errs := resolveContexts(ctx, queries)
+	if err := contextsError("resolve nodes", errs, len(queries)); err != nil {
+		return stageErrorf(errorStage(err, stageResolveNodes), "%w", err)
+	}
+	pOpts.targetNodes = sets.New[string]()
+	for _, t := range targets {
+		// the contexts are resolved concurrently
+		timings.matchSelectors = max(timings.matchSelectors, t.matchSelectors)
+		for name := range t.matchedNodes {
+			pOpts.targetNodes.Insert(nodeKey(t.name, name))
+		}
+		if opts.explain {
+			fmt.Fprintln(os.Stderr, t.logPrefix()+t.explanation)
+		}
 	}
-	klog.V(1).Infof("pod query strategy: %q", queryStrategy)
 
-	podsRestClient, err := makePodsRESTClient(kubeConfigFlags.ToRESTConfig)
-	if err != nil {
-		klog.Fatalf("failed to create REST client: %v", err)
+	if opts.dryRun {
+		for _, t := range targets {
+			if t.name != "" {
+				fmt.Printf("context:        %s\n", t.name)
+			}
+			fmt.Print(formatQueryPlan(t.queryPlan()))
+		}
+		return nil
 	}
 
-	var resp metav1.Table
-	switch queryStrategy {
-	case queryAllPods:
-		resp, err = findPodsByQueryingAllPods(ctx, podsRestClient, matchedNodes)
-	case queryPodPerNodeInParallel:
-		klog.V(1).Infof("querying list of pods on each node in parallel (workers: %d)", *numWorkers)
-		resp, err = findPodsByQueryingNodesInParallel(ctx, podsRestClient, matchedNodes.UnsortedList(), *numWorkers)
-	default:
-		klog.Fatalf("unknown pod query strategy: %q", queryStrategy)
+	var (
+		resp            metav1.Table
+		stats           queryStats
+		podContexts     map[types.UID]string
+		queriedContexts int
+	)
+	queryStart := time.Now()
+	// findPods queries the pods on the matched nodes, and filters them
+	findPods := func() (metav1.Table, queryStats, error) {
+		start := time.Now()
+		resp, ctxs, stats, errs := queryContexts(ctx, targets)
+		timings.queryPods += time.Since(start)
+		if err := contextsError("query pods", errs, len(targets)); err != nil {
+			return resp, stats, err
+		}
+		start = time.Now()
+		defer func() { timings.filter += time.Since(start) }()
+		podContexts, queriedContexts = ctxs, len(targets)-len(errs)
+		resp = dedupePodRows(resp)
+		klog.V(1).Infof("query matched %d pods", len(resp.Rows))
+		return filters.apply(resp, time.Now()), stats, nil
+	}
+	var waitTimedOut bool
+	if opts.waitFor == "" {
+		resp, stats, err = findPods()
+	} else {
+		resp, stats, err = waitForPods(ctx, findPods, func(t metav1.Table) bool { return allPodsInPhase(t, waitPhase) }, opts.pollInterval, opts.waitTimeout)
+		if errors.Is(err, errWaitTimeout) {
+			// print the pods that are not there yet before failing
+			waitTimedOut, err = true, nil
+		}
 	}
 	if err != nil {
-		klog.Fatalf("failed to query pods from Kubernetes API: %v", err)
+		return stageErrorf(stageQuery, "%w", err)
 	}
-	klog.V(1).Infof("query matched %d pods", len(resp.Rows))
+	queryDuration := time.Since(queryStart)
 
-	// Filter out daemonset pods if not requested
-	if !*includeDaemonSets {
-		resp = filterDaemonSetPods(resp)
+	if len(opts.contexts) > 0 {
+		tblOpts.podContexts = podContexts
+	}
+	if opts.showMatchedContainer {
+		tblOpts.matchedContainers = matchedContainers(resp, opts.image, opts.includeEphemeral)
+	}
+	if opts.highlightRecentRestarts {
+		tblOpts.recentRestarts = recentlyRestartedPods(resp, time.Now().Add(-opts.recentRestartWindow))
+	}
+	if tblOpts.needsNodes() || opts.sortByNodePressure || opts.nodeCapacity {
+		// get the matched nodes (fetching the ones specified by name)
+		tblOpts.nodes, err = getContextNodes(ctx, targets)
+		if err != nil {
+			return stageErrorf(stageResolveNodes, "%w", err)
+		}
 	}
 
 	// Consistent ordering for the output
-	slices.SortFunc(resp.Rows, cmpPodRow)
+	cmpRows := groupByCmps[opts.groupBy]
+	if tblOpts.podContexts != nil {
+		cmpGroup := cmpRows
+		cmpRows = func(a, b metav1.TableRow) int {
+			ctxA := tblOpts.podContexts[a.Object.Object.(*corev1.Pod).UID]
+			ctxB := tblOpts.podContexts[b.Object.Object.(*corev1.Pod).UID]
+			if ctxA != ctxB {
+				return strings.Compare(ctxA, ctxB)
+			}
+			return cmpGroup(a, b)
+		}
+	}
+	if opts.sortDescending {
+		cmpRows = reverseCmp(cmpRows)
+	}
+	slices.SortFunc(resp.Rows, cmpRows)
+	if opts.sortByNodePressure {
+		sortByNodePressureStable(resp.Rows, tblOpts.nodes, tblOpts.podContexts)
+	}
+
+	// Truncate the output after sorting, so the sample is deterministic
+	totalPods := len(resp.Rows)
+	if opts.maxPods > 0 {
+		resp = truncateRows(resp, opts.maxPods)
+	}
+
+	if tblOpts.showLastEvent {
+		tblOpts.lastEvents = lastContextPodEvents(ctx, targets, resp, podContexts)
+	}
 
 	// Print the results
-	if err := print(resp, printFlags); err != nil {
-		klog.Fatalf("print error: %v", err)
+	printStart := time.Now()
+	pOpts.tableOpts = tblOpts
+	if err := writeOutput(opts.outputFile, func(w io.Writer) error {
+		return print(w, resp, opts.printFlags, pOpts)
+	}); err != nil {
+		return stageErrorf(stagePrint, "print error: %w", err)
+	}
+	if err := writePodListFiles(resp, opts.alsoOutputJSON, opts.alsoOutputYAML, podListOpts{fullOutput: opts.fullOutput}); err != nil {
+		return stageErrorf(stagePrint, "%w", err)
 	}
+	timings.print = time.Since(printStart)
 
-	// if pprof server is configured, keep the program running
-	if *pprofAddr != "" {
-		klog.Info("keeping program alive for pprof inspection")
-		select {}
+	if !quiet && len(resp.Rows) < totalPods && isTableFormat(opts.printFlags) && !opts.listNodes && !opts.summaryOnly && opts.topNodes == 0 {
+		fmt.Fprintf(os.Stderr, "(showing %d of %d pods; use --max-pods to change)\n", len(resp.Rows), totalPods)
+	}
+
+	if opts.showStats {
+		if len(opts.contexts) > 0 {
+			fmt.Fprintf(os.Stderr, "contexts: %d, ", queriedContexts)
+		}
+		fmt.Fprintf(os.Stderr, "strategy: %s, pages: %d, pods retrieved: %d, pods matched: %d, query took: %v\n",
+			contextsStrategy(targets), stats.pages, stats.podsRetrieved, len(resp.Rows), queryDuration.Truncate(time.Millisecond))
+	}
+	if opts.verboseTimings {
+		for _, t := range targets {
+			timings.listNodes = max(timings.listNodes, t.nodes.fetchDuration)
+		}
+		timings.pages = stats.pages
+		if err := writeTimings(os.Stderr, timings); err != nil {
+			klog.Warningf("failed to write timings: %v", err)
+		}
+	}
+	if opts.metrics {
+		if err := writeMetrics(os.Stderr, runMetrics{strategy: contextsStrategy(targets), queryDuration: queryDuration, podsMatched: totalPods, stats: stats}); err != nil {
+			klog.Warningf("failed to write metrics: %v", err)
+		}
+	}
+
+	pprofDone()
+	if waitTimedOut {
+		return stageErrorf(stageQuery, "timed out after %v waiting for all matched pods to be %s", opts.waitTimeout, waitPhase)
+	}
+	return nil
+}
+
+// setRateLimits sets the client-side QPS/Burst limits of the REST config,
+// defaulting to 3x the number of workers and 3x the QPS respectively.
+func setRateLimits(restCfg *rest.Config, numWorkers int64, qps float32, burst int) {
+	restCfg.QPS = float32(numWorkers) * 3
+	if qps > 0 {
+		restCfg.QPS = qps
+	}
+	restCfg.Burst = int(restCfg.QPS) * 3
+	if burst > 0 {
+		restCfg.Burst = burst
 	}
 }
 
@@ -176,45 +878,272 @@ func makePodsRESTClient(makeRestCfg restCfgFactory) (*rest.RESTClient, error) {
 	restCfg.APIPath = "/api"
 	restCfg.GroupVersion = ptr.To(corev1.SchemeGroupVersion)
 	restCfg.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
-	restCfg.UserAgent = "kubectl-pods_on"
+	if restCfg.UserAgent == "" {
+		restCfg.UserAgent = defaultUserAgent()
+	}
 	return rest.RESTClientFor(restCfg)
 }
 
-// resolveNodeNames returns the names of nodes that match the given selectors,
-// and the total number of nodes in the cluster.
-func resolveNodeNames(ctx context.Context, nodeClient typedcorev1.NodeInterface, selectors []labels.Selector) (sets.Set[string], int, error) {
+// defaultUserAgent returns the User-Agent sent to the API server unless
+// overridden with --user-agent.
+func defaultUserAgent() string {
+	return "kubectl-pods_on/" + version
+}
+
+func userAgentOrDefault(userAgent string) string {
+	if userAgent == "" {
+		return defaultUserAgent()
+	}
+	return userAgent
+}
+
+// resolveNodeNames returns the names of the given nodes that match the
+// selectors (or all the nodes if no selectors are given).
+func resolveNodeNames(ctx context.Context, nodes map[string]*corev1.Node, selectors []labels.Selector) sets.Set[string] {
 	start := time.Now()
+	nodeList := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		nodeList = append(nodeList, node)
+	}
+	out := matchNodeSelectors(ctx, nodeList, selectors, int64(goruntime.GOMAXPROCS(0)))
+	klog.V(3).Infof("matching node selectors took %s", time.Since(start).Truncate(time.Millisecond))
+	return out
+}
 
-	var nodeList []*corev1.Node
-	// Use a pager to handle paginated node listing
-	p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
-		return nodeClient.List(ctx, opts)
-	})
+// splitNodeIPs separates the IP addresses (IPv4 or IPv6) from the node names.
+func splitNodeIPs(args []string) (nodeNames, ips []string) {
+	for _, arg := range args {
+		if net.ParseIP(arg) != nil {
+			ips = append(ips, arg)
+		} else {
+			nodeNames = append(nodeNames, arg)
+		}
+	}
+	return nodeNames, ips
+}
 
-	err := p.EachListItem(ctx, metav1.ListOptions{
-		Limit: 500, // pagination!
-	}, func(obj runtime.Object) error {
-		nodeList = append(nodeList, obj.(*corev1.Node))
-		return nil
-	})
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to list nodes in the cluster: %w", err)
+// nodeNamesByInternalIP returns the names of the nodes that have one of the
+// given IPs as an InternalIP address (or as their name), and the sorted list
+// of IPs that didn't match any node.
+func nodeNamesByInternalIP(nodes map[string]*corev1.Node, ips []string) (sets.Set[string], []string) {
+	out := sets.New[string]()
+	unknown := sets.New[string]()
+	for _, ip := range ips {
+		want := net.ParseIP(ip)
+		found := false
+		for name, node := range nodes {
+			if name == ip {
+				out.Insert(name)
+				found = true
+				continue
+			}
+			for _, addr := range node.Status.Addresses {
+				if addr.Type == corev1.NodeInternalIP && want.Equal(net.ParseIP(addr.Address)) {
+					out.Insert(name)
+					found = true
+				}
+			}
+		}
+		if !found {
+			unknown.Insert(ip)
+		}
 	}
+	return out, sets.List(unknown)
+}
 
-	klog.V(3).Infof("list nodes took %v (%d nodes)", time.Since(start), len(nodeList))
+// truncateRows returns the table with only the first n rows.
+func truncateRows(in metav1.Table, n int) metav1.Table {
+	if len(in.Rows) > n {
+		in.Rows = in.Rows[:n]
+	}
+	return in
+}
 
-	start = time.Now()
-	nodes := sets.New[string]()
-	for _, node := range nodeList {
-		for _, selector := range selectors {
-			if selector.Matches(labels.Set(node.Labels)) {
-				nodes.Insert(node.Name)
-				break
+// notReadyNodeNames returns the names of the nodes whose Ready condition is
+// not True (False, Unknown or missing).
+func notReadyNodeNames(nodes map[string]*corev1.Node) sets.Set[string] {
+	out := sets.New[string]()
+	for name, node := range nodes {
+		if nodeReadyStatus(node) != "Ready" {
+			out.Insert(name)
+		}
+	}
+	return out
+}
+
+// unknownNodeNames returns the sorted list of requested node names that are
+// not in the existing set of nodes.
+func unknownNodeNames(requested []string, existing sets.Set[string]) []string {
+	return sets.List(sets.New(requested...).Difference(existing))
+}
+
+// resolveShortNodeNames replaces each of the requested node names that is not
+// an existing node with the existing node whose first DNS label is the name
+// (e.g. node1 for node1.example.com). It returns an error listing the
+// candidates if more than one node has the short name. Names that match no
+// node are returned unchanged.
+func resolveShortNodeNames(requested []string, existing sets.Set[string]) ([]string, error) {
+	byShortName := make(map[string][]string)
+	for name := range existing {
+		short := strings.SplitN(name, ".", 2)[0]
+		byShortName[short] = append(byShortName[short], name)
+	}
+	out := make([]string, 0, len(requested))
+	for _, name := range requested {
+		candidates := byShortName[name]
+		switch {
+		case existing.Has(name) || len(candidates) == 0:
+			out = append(out, name)
+		case len(candidates) == 1:
+			out = append(out, candidates[0])
+		default:
+			slices.Sort(candidates)
+			return nil, fmt.Errorf("short node name %q is ambiguous, matches: %s", name, strings.Join(candidates, ", "))
+		}
+	}
+	return out, nil
+}
+
+// matchNodeSelectors returns the names of the nodes that match any of the
+// given selectors (or all nodes if no selectors are given), by evaluating
+// chunks of the node list in parallel.
+func matchNodeSelectors(ctx context.Context, nodeList []*corev1.Node, selectors []labels.Selector, numWorkers int64) sets.Set[string] {
+	var (
+		out = sets.New[string]()
+		mu  sync.Mutex
+	)
+	numWorkers = max(numWorkers, 1)
+	chunkSize := max((len(nodeList)+int(numWorkers)-1)/int(numWorkers), 1)
+
+	g := semgroup.NewGroup(ctx, numWorkers)
+	for start := 0; start < len(nodeList); start += chunkSize {
+		chunk := nodeList[start:min(start+chunkSize, len(nodeList))]
+		g.Go(func() error {
+			matched := make([]string, 0, len(chunk))
+			for _, node := range chunk {
+				if len(selectors) == 0 {
+					matched = append(matched, node.Name)
+					continue
+				}
+				for _, selector := range selectors {
+					if selector.Matches(labels.Set(node.Labels)) {
+						matched = append(matched, node.Name)
+						break
+					}
+				}
 			}
+			mu.Lock()
+			out.Insert(matched...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	_ = g.Wait() // workers never return errors
+	return out
+}
+
+// podFilters are the client-side filters applied to the queried pods.
+type podFilters struct {
+	includeDaemonSets bool
+	owner, ownerKind  string
+	image             string
+	includeEphemeral  bool
+	since, olderThan  time.Duration
+	notReady          bool
+	probeFailures     bool
+	conditions        []corev1.PodCondition // only the type and status are matched
+	succeededMaxAge   time.Duration         // hide the Succeeded pods completed longer ago
+	excludeLabels     labels.Selector       // hide the pods matching it (if not nil)
+}
+
+// metadataOnly returns whether the filters only need the pods' metadata (and
+// spec.nodeName). Only the filters listed here are known to, setting any other
+// filter needs the full pods.
+func (f podFilters) metadataOnly() bool {
+	return reflect.DeepEqual(f, podFilters{
+		includeDaemonSets: f.includeDaemonSets,
+		owner:             f.owner,
+		ownerKind:         f.ownerKind,
+		includeEphemeral:  f.includeEphemeral, // only used with image
+		since:             f.since,
+		olderThan:         f.olderThan,
+		excludeLabels:     f.excludeLabels,
+	})
+}
+
+// apply returns the pods in the table that pass the filters.
+func (f podFilters) apply(in metav1.Table, now time.Time) metav1.Table {
+	// Filter out daemonset pods if not requested
+	if !f.includeDaemonSets {
+		in = filterDaemonSetPods(in)
+	}
+
+	// Filter out pods by labels if requested (--selector is applied by the
+	// API server)
+	if f.excludeLabels != nil {
+		in = filterPodsByLabels(in, f.excludeLabels)
+	}
+
+	// Filter pods by owner if requested
+	if f.owner != "" {
+		in = filterPodsByOwner(in, f.owner, f.ownerKind)
+	}
+
+	// Filter pods by container image if requested
+	if f.image != "" {
+		in = filterPodsByImage(in, f.image, f.includeEphemeral)
+	}
+
+	// Filter pods by age if requested
+	if f.since > 0 || f.olderThan > 0 {
+		in = filterPodsByAge(in, now, f.since, f.olderThan)
+	}
+
+	// Filter out ready pods if requested
+	if f.notReady {
+		in = filterNotReadyPods(in)
+	}
+
+	// Filter out the pods without signs of failing probes if requested
+	if f.probeFailures {
+		in = filterProbeFailurePods(in, now)
+	}
+
+	// Filter pods by conditions if requested
+	if len(f.conditions) > 0 {
+		in = filterPodsByConditions(in, f.conditions)
+	}
+
+	// Filter out old completed pods if requested
+	if f.succeededMaxAge > 0 {
+		in = filterOldSucceededPods(in, now, f.succeededMaxAge)
+	}
+	return in
+}
+
+// dedupePodRows removes the duplicate pods (by namespace, name and UID) from
+// the table, keeping the first occurrence.
+func dedupePodRows(in metav1.Table) metav1.Table {
+	type podKey struct {
+		namespace, name string
+		uid             types.UID
+	}
+	seen := make(map[podKey]bool, len(in.Rows))
+	filtered := make([]metav1.TableRow, 0, len(in.Rows))
+	for _, podRow := range in.Rows {
+		pod := podRow.Object.Object.(*corev1.Pod)
+		key := podKey{pod.Namespace, pod.Name, pod.UID}
+		if seen[key] {
+			continue
 		}
+		seen[key] = true
+		filtered = append(filtered, podRow)
 	}
-	klog.V(3).Infof("matching node selectors took %s", time.Since(start).Truncate(time.Millisecond))
-	return nodes, len(nodeList), nil
+	if n := len(in.Rows) - len(filtered); n > 0 {
+		klog.V(2).Infof("removed %d duplicate pods", n)
+	}
+	in.Rows = filtered
+	return in
 }
 
 // filterDaemonSetPods returns a new slice of pods that are not part of a DaemonSet.
@@ -237,6 +1166,277 @@ func filterDaemonSetPods(in metav1.Table) metav1.Table {
 	return in
 }
 
+// filterPodsByOwner returns the pods owned by a workload with the given name
+// (and kind, if specified). Pods of a Deployment are matched through their
+// owner ReplicaSet, based on the "<deployment>-<hash>" naming of ReplicaSets.
+func filterPodsByOwner(in metav1.Table, name, kind string) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if isOwnedBy(podRow.Object.Object.(*corev1.Pod), name, kind) {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d pods by owner out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+func isOwnedBy(pod *corev1.Pod, name, kind string) bool {
+	for _, ref := range pod.OwnerReferences {
+		// direct owner (ReplicaSet, StatefulSet, DaemonSet, Job, ...)
+		if ref.Name == name && (kind == "" || strings.EqualFold(ref.Kind, kind)) {
+			return true
+		}
+		// Deployment via the ReplicaSet named <deployment>-<hash>
+		if ref.Kind == "ReplicaSet" && (kind == "" || strings.EqualFold(kind, "Deployment")) {
+			if hash, ok := strings.CutPrefix(ref.Name, name+"-"); ok && hash != "" && !strings.Contains(hash, "-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterPodsByImage returns the pods that have a container with an image
+// containing the given substring. Ephemeral (debug) containers are only
+// considered if includeEphemeral is set.
+func filterPodsByImage(in metav1.Table, image string, includeEphemeral bool) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if len(matchContainerImages(podRow.Object.Object.(*corev1.Pod), image, includeEphemeral)) > 0 {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d pods by image out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+// matchedContainers returns the names of the containers matching the image
+// substring (comma-separated) of each pod in the table (by pod UID).
+func matchedContainers(in metav1.Table, image string, includeEphemeral bool) map[types.UID]string {
+	out := make(map[types.UID]string, len(in.Rows))
+	for _, podRow := range in.Rows {
+		pod := podRow.Object.Object.(*corev1.Pod)
+		out[pod.UID] = strings.Join(matchContainerImages(pod, image, includeEphemeral), ",")
+	}
+	return out
+}
+
+// matchContainerImages returns the names of the pod's containers with an
+// image containing the given substring.
+func matchContainerImages(pod *corev1.Pod, image string, includeEphemeral bool) []string {
+	var names []string
+	for _, c := range podContainers(pod, includeEphemeral) {
+		if strings.Contains(c.image, image) {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// podContainer is the name and image of a container in a pod. Init and
+// ephemeral containers' names are prefixed with "init:" and "ephemeral:".
+type podContainer struct {
+	name, image string
+}
+
+// podContainers returns the pod's init containers and containers, and its
+// ephemeral containers if includeEphemeral is set.
+func podContainers(pod *corev1.Pod, includeEphemeral bool) []podContainer {
+	var out []podContainer
+	for _, c := range pod.Spec.InitContainers {
+		out = append(out, podContainer{name: "init:" + c.Name, image: c.Image})
+	}
+	for _, c := range pod.Spec.Containers {
+		out = append(out, podContainer{name: c.Name, image: c.Image})
+	}
+	if includeEphemeral {
+		for _, c := range pod.Spec.EphemeralContainers {
+			out = append(out, podContainer{name: "ephemeral:" + c.Name, image: c.Image})
+		}
+	}
+	return out
+}
+
+// filterNotReadyPods returns the pods that are not in the Running phase or
+// have a container that is not ready. Pods without container statuses yet
+// are considered not ready.
+func filterNotReadyPods(in metav1.Table) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if !podReady(podRow.Object.Object.(*corev1.Pod)) {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d ready pods out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// probeFailureWindow is how recently the readiness of a pod with a running
+// but unready container must have changed for --probe-failures.
+const probeFailureWindow = 10 * time.Minute
+
+// filterProbeFailurePods returns the pods that are likely failing their
+// liveness or readiness probes (see likelyProbeFailure).
+func filterProbeFailurePods(in metav1.Table, now time.Time) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if likelyProbeFailure(podRow.Object.Object.(*corev1.Pod), now) {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d pods without probe failures out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+// likelyProbeFailure returns whether a container of the pod:
+//   - has restarted, and was last terminated by a signal the kubelet sends to
+//     containers failing their liveness probe (exit code 137 for SIGKILL or
+//     143 for SIGTERM) but not for running out of memory (OOMKilled), or
+//   - is running but not ready (failing its readiness probe), and the pod's
+//     Ready condition changed within the probeFailureWindow before now.
+//
+// The kubelet doesn't record the probe failures in the container statuses, so
+// this is a heuristic: e.g. a container killed by its own process with
+// SIGKILL also matches.
+func likelyProbeFailure(pod *corev1.Pod, now time.Time) bool {
+	readyChangedRecently := false
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && now.Sub(c.LastTransitionTime.Time) <= probeFailureWindow {
+			readyChangedRecently = true
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if t := cs.LastTerminationState.Terminated; cs.RestartCount > 0 && t != nil &&
+			(t.ExitCode == 137 || t.ExitCode == 143) && t.Reason != "OOMKilled" {
+			return true
+		}
+		if cs.State.Running != nil && !cs.Ready && readyChangedRecently {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPodsByConditions returns the pods that have all the given conditions
+// (matched by type and status) in their status.
+func filterPodsByConditions(in metav1.Table, conditions []corev1.PodCondition) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if hasPodConditions(podRow.Object.Object.(*corev1.Pod), conditions) {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d pods by conditions out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+func hasPodConditions(pod *corev1.Pod, conditions []corev1.PodCondition) bool {
+	for _, want := range conditions {
+		if !slices.ContainsFunc(pod.Status.Conditions, func(c corev1.PodCondition) bool {
+			return c.Type == want.Type && c.Status == want.Status
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterPodsByLabels returns the pods whose labels don't match the exclude
+// selector.
+func filterPodsByLabels(in metav1.Table, exclude labels.Selector) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		if !exclude.Matches(labels.Set(podRow.Object.Object.(*corev1.Pod).Labels)) {
+			filtered = append(filtered, podRow)
+		}
+	}
+	klog.V(2).Infof("filtered out %d pods by labels out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+// filterOldSucceededPods returns the pods except the Succeeded ones that
+// completed more than olderThan ago relative to now.
+func filterOldSucceededPods(in metav1.Table, now time.Time, olderThan time.Duration) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		pod := podRow.Object.Object.(*corev1.Pod)
+		if pod.Status.Phase == corev1.PodSucceeded && podCompletionTime(pod).Before(now.Add(-olderThan)) {
+			continue
+		}
+		filtered = append(filtered, podRow)
+	}
+	klog.V(2).Infof("filtered out %d old Succeeded pods out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+// podCompletionTime returns the time the last container of the pod
+// terminated, or its creation time if none of its containers terminated.
+func podCompletionTime(pod *corev1.Pod) time.Time {
+	var out time.Time
+	for _, cs := range pod.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil && t.FinishedAt.After(out) {
+			out = t.FinishedAt.Time
+		}
+	}
+	if out.IsZero() {
+		return pod.CreationTimestamp.Time
+	}
+	return out
+}
+
+// filterPodsByAge returns the pods created within the since duration (if
+// non-zero), and created more than olderThan ago (if non-zero) relative to
+// now. A pod created exactly since ago is kept, and a pod created exactly
+// olderThan ago is filtered out.
+func filterPodsByAge(in metav1.Table, now time.Time, since, olderThan time.Duration) metav1.Table {
+	var filtered []metav1.TableRow
+	for _, podRow := range in.Rows {
+		created := podRow.Object.Object.(*corev1.Pod).CreationTimestamp.Time
+		if since > 0 && created.Before(now.Add(-since)) {
+			continue
+		}
+		if olderThan > 0 && !created.Before(now.Add(-olderThan)) {
+			continue
+		}
+		filtered = append(filtered, podRow)
+	}
+	klog.V(2).Infof("filtered out %d pods by age out of %d", len(in.Rows)-len(filtered), len(in.Rows))
+	in.Rows = filtered
+	return in
+}
+
+// sortByNodePressureStable sorts the pod rows so that the pods on the nodes
+// with more pressure conditions come first, keeping the existing order
+// otherwise. The nodes are keyed by nodeKey with the pods' contexts.
+func sortByNodePressureStable(rows []metav1.TableRow, nodes map[string]*corev1.Node, podContexts map[types.UID]string) {
+	podNode := func(row metav1.TableRow) *corev1.Node {
+		pod := row.Object.Object.(*corev1.Pod)
+		return nodes[nodeKey(podContexts[pod.UID], pod.Spec.NodeName)]
+	}
+	slices.SortStableFunc(rows, func(a, b metav1.TableRow) int {
+		return nodePressure(podNode(b)) - nodePressure(podNode(a))
+	})
+}
+
 // cmpPodRow sorts pods by node name, then by namespace, then by name.
 func cmpPodRow(rowA, rowB metav1.TableRow) int {
 	a := rowA.Object.Object.(*corev1.Pod)
@@ -256,4 +1456,28 @@ func cmpPod(a, b corev1.Pod) int {
 	return strings.Compare(a.Name, b.Name)
 }
 
+// cmpPodRowByNamespace sorts pods by namespace, then by node name, then by
+// name (--group-by=namespace).
+func cmpPodRowByNamespace(rowA, rowB metav1.TableRow) int {
+	a := rowA.Object.Object.(*corev1.Pod)
+	b := rowB.Object.Object.(*corev1.Pod)
+	if a.Namespace != b.Namespace {
+		return strings.Compare(a.Namespace, b.Namespace)
+	}
+	return cmpPod(*a, *b)
+}
+
+// groupByCmps are the --group-by dimensions and the functions sorting the
+// pod rows by them first.
+var groupByCmps = map[string]func(a, b metav1.TableRow) int{
+	"node":      cmpPodRow,
+	"namespace": cmpPodRowByNamespace,
+}
+
+// reverseCmp returns a comparison function that sorts in the reverse order
+// of cmp.
+func reverseCmp[T any](cmp func(a, b T) int) func(a, b T) int {
+	return func(a, b T) int { return -cmp(a, b) }
+}
+
 type restCfgFactory func() (*rest.Config, error)
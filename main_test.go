@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"slices"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestFilterDaemonSetPods(t *testing.T) {
@@ -54,3 +69,650 @@ func TestCmpPod(t *testing.T) {
 
 	require.Equal(t, []corev1.Pod{p_n1_a_a, p_n1_a_b, p_n1_b_a, p_n2_a_a}, v)
 }
+
+func TestReverseCmp(t *testing.T) {
+	var rows []metav1.TableRow
+	for _, p := range []struct{ node, ns, name string }{
+		{"node2", "a", "a"}, {"node1", "b", "a"}, {"node1", "a", "b"}, {"node1", "a", "a"}, {"node3", "c", "c"},
+	} {
+		rows = append(rows, metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: p.ns, Name: p.name},
+			Spec:       corev1.PodSpec{NodeName: p.node},
+		}}})
+	}
+	asc := slices.Clone(rows)
+	slices.SortFunc(asc, cmpPodRow)
+	desc := slices.Clone(rows)
+	slices.SortFunc(desc, reverseCmp(cmpPodRow))
+
+	slices.Reverse(desc)
+	require.Equal(t, asc, desc)
+}
+
+func TestCmpPodRowByNamespace(t *testing.T) {
+	row := func(node, ns, name string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}}}
+	}
+	rows := []metav1.TableRow{
+		row("node1", "b", "a"),
+		row("node2", "a", "a"),
+		row("node1", "a", "b"),
+		row("node1", "a", "a"),
+	}
+	key := func(rows []metav1.TableRow) []string {
+		var out []string
+		for _, r := range rows {
+			pod := r.Object.Object.(*corev1.Pod)
+			out = append(out, pod.Namespace+"/"+pod.Spec.NodeName+"/"+pod.Name)
+		}
+		return out
+	}
+
+	slices.SortFunc(rows, groupByCmps["namespace"])
+	require.Equal(t, []string{"a/node1/a", "a/node1/b", "a/node2/a", "b/node1/a"}, key(rows))
+	slices.SortFunc(rows, groupByCmps["node"])
+	require.Equal(t, []string{"a/node1/a", "a/node1/b", "b/node1/a", "a/node2/a"}, key(rows))
+}
+
+func TestSortByNodePressureStable(t *testing.T) {
+	node := func(conds ...corev1.NodeConditionType) *corev1.Node {
+		n := &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+		}}}
+		for _, c := range conds {
+			n.Status.Conditions = append(n.Status.Conditions, corev1.NodeCondition{Type: c, Status: corev1.ConditionTrue})
+		}
+		return n
+	}
+	nodes := map[string]*corev1.Node{
+		"a-healthy":  node(),
+		"b-disk":     node(corev1.NodeDiskPressure),
+		"c-healthy":  node(),
+		"d-mem-pid":  node(corev1.NodeMemoryPressure, corev1.NodePIDPressure),
+		"e-pid":      node(corev1.NodePIDPressure),
+		"f-notfound": nil,
+	}
+	var rows []metav1.TableRow
+	for _, p := range []struct{ node, ns, name string }{
+		{"a-healthy", "ns1", "p1"}, {"b-disk", "ns1", "p2"}, {"b-disk", "ns2", "p1"},
+		{"c-healthy", "ns1", "p3"}, {"d-mem-pid", "ns1", "p4"}, {"e-pid", "ns1", "p5"},
+		{"f-notfound", "ns1", "p6"}, {"", "ns1", "unscheduled"},
+	} {
+		rows = append(rows, metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: p.ns, Name: p.name},
+			Spec:       corev1.PodSpec{NodeName: p.node},
+		}}})
+	}
+	slices.SortFunc(rows, cmpPodRow)
+	sortByNodePressureStable(rows, nodes, nil)
+
+	var got []string
+	for _, row := range rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		got = append(got, pod.Spec.NodeName+"/"+pod.Namespace+"/"+pod.Name)
+	}
+	require.Equal(t, []string{
+		"d-mem-pid/ns1/p4",
+		"b-disk/ns1/p2",
+		"b-disk/ns2/p1",
+		"e-pid/ns1/p5",
+		"/ns1/unscheduled",
+		"a-healthy/ns1/p1",
+		"c-healthy/ns1/p3",
+		"f-notfound/ns1/p6",
+	}, got)
+}
+
+func TestMatchNodeSelectors(t *testing.T) {
+	var nodes []*corev1.Node
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("node%d", i),
+			Labels: map[string]string{"idx": fmt.Sprint(i % 10)},
+		}})
+	}
+	sel := func(s string) labels.Selector {
+		out, err := labels.Parse(s)
+		require.NoError(t, err)
+		return out
+	}
+
+	for _, workers := range []int64{1, 3, 200} {
+		out := matchNodeSelectors(context.Background(), nodes, []labels.Selector{sel("idx=1"), sel("idx in (2,3)")}, workers)
+		require.Equal(t, 30, out.Len(), "workers=%d", workers)
+		require.True(t, out.HasAll("node1", "node2", "node93"))
+		require.False(t, out.Has("node0"))
+
+		require.Equal(t, 100, matchNodeSelectors(context.Background(), nodes, nil, workers).Len())
+	}
+	require.Empty(t, matchNodeSelectors(context.Background(), nil, []labels.Selector{sel("idx=1")}, 4))
+}
+
+func BenchmarkMatchNodeSelectors(b *testing.B) {
+	var nodes []*corev1.Node
+	for i := 0; i < 50_000; i++ {
+		nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("node%d", i),
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone": fmt.Sprintf("zone-%d", i%5),
+				"pool":                        fmt.Sprintf("pool-%d", i%20),
+			},
+		}})
+	}
+	var selectors []labels.Selector
+	for _, s := range []string{
+		"topology.kubernetes.io/zone in (zone-1, zone-3)",
+		"pool notin (pool-1, pool-2, pool-3), topology.kubernetes.io/zone!=zone-0",
+		"pool=pool-19",
+	} {
+		sel, err := labels.Parse(s)
+		require.NoError(b, err)
+		selectors = append(selectors, sel)
+	}
+
+	for _, workers := range []int64{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matchNodeSelectors(context.Background(), nodes, selectors, workers)
+			}
+		})
+	}
+}
+
+func TestResolveNodeNamesNegatedSelectors(t *testing.T) {
+	nodes := map[string]*corev1.Node{
+		"spot1":     {ObjectMeta: metav1.ObjectMeta{Name: "spot1", Labels: map[string]string{"spot": "true"}}},
+		"ondemand1": {ObjectMeta: metav1.ObjectMeta{Name: "ondemand1", Labels: map[string]string{"spot": "false"}}},
+		"unlabeled": {ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	}
+	for _, s := range []string{"spot!=true", "spot notin (true)"} {
+		t.Run(s, func(t *testing.T) {
+			selectors, _, err := parsePosArgs([]string{s}, posArgsAuto)
+			require.NoError(t, err)
+			// nodes without the label match, just like kubectl get nodes -l
+			require.Equal(t, []string{"ondemand1", "unlabeled"}, sets.List(resolveNodeNames(context.Background(), nodes, selectors)))
+		})
+	}
+	t.Run("!spot", func(t *testing.T) {
+		selectors, _, err := parsePosArgs([]string{"!spot"}, posArgsAuto)
+		require.NoError(t, err)
+		require.Equal(t, []string{"unlabeled"}, sets.List(resolveNodeNames(context.Background(), nodes, selectors)))
+	})
+}
+
+func TestResolveNodeNamesWithFieldSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1", Labels: map[string]string{"role": "worker"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker2", Labels: map[string]string{"role": "worker"}}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "infra1", Labels: map[string]string{"role": "infra"}}},
+	)
+	// the fake clientset doesn't support field selectors
+	client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sel := action.(k8stesting.ListAction).GetListRestrictions().Fields
+		list, err := client.Tracker().List(corev1.SchemeGroupVersion.WithResource("nodes"), corev1.SchemeGroupVersion.WithKind("Node"), "")
+		if err != nil {
+			return true, nil, err
+		}
+		nodeList := list.(*corev1.NodeList)
+		var items []corev1.Node
+		for _, n := range nodeList.Items {
+			if sel.Matches(fields.Set{"metadata.name": n.Name, "spec.unschedulable": strconv.FormatBool(n.Spec.Unschedulable)}) {
+				items = append(items, n)
+			}
+		}
+		nodeList.Items = items
+		return true, nodeList, nil
+	})
+
+	fieldSelector, err := parseNodeFieldSelector("spec.unschedulable=false")
+	require.NoError(t, err)
+	selectors, _, err := parsePosArgs([]string{"role=worker"}, posArgsAuto)
+	require.NoError(t, err)
+
+	nodes, err := newNodeCache(client.CoreV1().Nodes(), fieldSelector.String(), 2, 0).list(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"infra1", "worker1"}, sets.List(sets.KeySet(nodes)))
+	require.Equal(t, []string{"worker1"}, sets.List(resolveNodeNames(context.Background(), nodes, selectors)))
+}
+
+func TestNotReadyNodeNames(t *testing.T) {
+	node := func(conds ...corev1.NodeCondition) *corev1.Node {
+		return &corev1.Node{Status: corev1.NodeStatus{Conditions: conds}}
+	}
+	nodes := map[string]*corev1.Node{
+		"ready":       node(corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue}, corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}),
+		"not-ready":   node(corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionFalse}),
+		"unknown":     node(corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionUnknown}),
+		"no-ready":    node(corev1.NodeCondition{Type: corev1.NodeDiskPressure, Status: corev1.ConditionFalse}),
+		"no-statuses": node(),
+	}
+	require.Equal(t, []string{"no-ready", "no-statuses", "not-ready", "unknown"}, sets.List(notReadyNodeNames(nodes)))
+}
+
+func TestUnknownNodeNames(t *testing.T) {
+	existing := sets.New("node1", "node2")
+	require.Empty(t, unknownNodeNames([]string{"node1", "node2"}, existing))
+	require.Empty(t, unknownNodeNames(nil, existing))
+	require.Equal(t, []string{"node3", "nodee1"}, unknownNodeNames([]string{"nodee1", "node1", "node3", "node3"}, existing))
+	require.Equal(t, []string{"node1"}, unknownNodeNames([]string{"node1"}, sets.New[string]()))
+}
+
+func TestResolveShortNodeNames(t *testing.T) {
+	existing := sets.New("node1.example.com", "node2.example.com", "node2.other.com", "node3", "node3.example.com")
+
+	got, err := resolveShortNodeNames([]string{"node1", "node1.example.com", "node3", "node4"}, existing)
+	require.NoError(t, err)
+	require.Equal(t, []string{"node1.example.com", "node1.example.com", "node3", "node4"}, got, "exact names take precedence, unknown names are kept")
+
+	got, err = resolveShortNodeNames([]string{"node2.other.com"}, existing)
+	require.NoError(t, err)
+	require.Equal(t, []string{"node2.other.com"}, got)
+
+	_, err = resolveShortNodeNames([]string{"node1", "node2"}, existing)
+	require.EqualError(t, err, `short node name "node2" is ambiguous, matches: node2.example.com, node2.other.com`)
+}
+
+func TestNodeNamesByInternalIP(t *testing.T) {
+	node := func(name string, addrs ...corev1.NodeAddress) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: corev1.NodeStatus{Addresses: addrs}}
+	}
+	nodes := map[string]*corev1.Node{
+		"node1": node("node1",
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "node1"}),
+		"node2": node("node2",
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.2"},
+			corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "34.1.2.3"}),
+		"10.0.0.3": node("10.0.0.3"),
+	}
+
+	names, unknown := nodeNamesByInternalIP(nodes, []string{"10.0.0.2", "fd00:0:0::1", "10.0.0.3"})
+	require.Equal(t, []string{"10.0.0.3", "node1", "node2"}, sets.List(names))
+	require.Empty(t, unknown)
+
+	names, unknown = nodeNamesByInternalIP(nodes, []string{"34.1.2.3", "fd00::2", "10.0.0.1"})
+	require.Equal(t, []string{"node1"}, sets.List(names))
+	require.Equal(t, []string{"34.1.2.3", "fd00::2"}, unknown, "external IPs should not match")
+}
+
+func TestSplitNodeIPs(t *testing.T) {
+	names, ips := splitNodeIPs([]string{"node1", "10.0.0.1", "fd00::1", "ip-10-0-0-1.ec2.internal", "10.0.0.1.nip.io"})
+	require.Equal(t, []string{"node1", "ip-10-0-0-1.ec2.internal", "10.0.0.1.nip.io"}, names)
+	require.Equal(t, []string{"10.0.0.1", "fd00::1"}, ips)
+}
+
+func TestTruncateRows(t *testing.T) {
+	rows := []metav1.TableRow{{Cells: []interface{}{"a"}}, {Cells: []interface{}{"b"}}, {Cells: []interface{}{"c"}}}
+
+	require.Equal(t, rows[:2], truncateRows(metav1.Table{Rows: rows}, 2).Rows)
+	require.Equal(t, rows, truncateRows(metav1.Table{Rows: rows}, 3).Rows)
+	require.Equal(t, rows, truncateRows(metav1.Table{Rows: rows}, 10).Rows)
+	require.Empty(t, truncateRows(metav1.Table{}, 1).Rows)
+}
+
+func TestFilterPodsByAge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	row := func(name string, age time.Duration) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}}}}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("10m", 10*time.Minute),
+		row("1h", time.Hour),
+		row("1h1s", time.Hour+time.Second),
+		row("2h", 2*time.Hour),
+	}}
+	names := func(t metav1.Table) []string {
+		var out []string
+		for _, r := range t.Rows {
+			out = append(out, r.Object.Object.(*corev1.Pod).Name)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{"10m", "1h"}, names(filterPodsByAge(in, now, time.Hour, 0)))
+	require.Equal(t, []string{"1h1s", "2h"}, names(filterPodsByAge(in, now, 0, time.Hour)))
+	require.Equal(t, []string{"1h1s"}, names(filterPodsByAge(in, now, 90*time.Minute, time.Hour)))
+	require.Equal(t, names(in), names(filterPodsByAge(in, now, 0, 0)))
+}
+
+func TestFilterNotReadyPods(t *testing.T) {
+	row := func(name string, phase corev1.PodPhase, ready ...bool) metav1.TableRow {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: corev1.PodStatus{Phase: phase}}
+		for _, r := range ready {
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{Ready: r})
+		}
+		return metav1.TableRow{Object: runtime.RawExtension{Object: pod}}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("ready", corev1.PodRunning, true, true),
+		row("partially-ready", corev1.PodRunning, true, false),
+		row("pending", corev1.PodPending),
+		row("no-statuses", corev1.PodRunning),
+		row("succeeded", corev1.PodSucceeded, false),
+	}}
+	var names []string
+	for _, r := range filterNotReadyPods(in).Rows {
+		names = append(names, r.Object.Object.(*corev1.Pod).Name)
+	}
+	require.Equal(t, []string{"partially-ready", "pending", "no-statuses", "succeeded"}, names)
+}
+
+func TestFilterPodsByLabels(t *testing.T) {
+	row := func(name string, podLabels map[string]string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: podLabels},
+		}}}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("web", map[string]string{"tier": "frontend", "app": "web"}),
+		row("fluentd", map[string]string{"tier": "frontend", "app": "fluentd"}),
+		row("db", map[string]string{"tier": "backend", "app": "db"}),
+		row("no-labels", nil),
+	}}
+	names := func(t metav1.Table) []string {
+		var out []string
+		for _, r := range t.Rows {
+			out = append(out, r.Object.Object.(*corev1.Pod).Name)
+		}
+		return out
+	}
+	sel := func(s string) labels.Selector {
+		out, err := labels.Parse(s)
+		require.NoError(t, err)
+		return out
+	}
+
+	require.Equal(t, []string{"web", "db", "no-labels"}, names(filterPodsByLabels(in, sel("app=fluentd"))))
+	require.Equal(t, []string{"no-labels"}, names(filterPodsByLabels(in, sel("tier"))))
+}
+
+func TestFilterProbeFailurePods(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	row := func(name string, readyChanged time.Duration, statuses ...corev1.ContainerStatus) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type: corev1.PodReady, LastTransitionTime: metav1.NewTime(now.Add(-readyChanged)),
+				}},
+				ContainerStatuses: statuses,
+			},
+		}}}
+	}
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	killed := func(reason string, exitCode int32) corev1.ContainerState {
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: reason, ExitCode: exitCode}}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("healthy", time.Minute, corev1.ContainerStatus{Ready: true, State: running}),
+		row("liveness-killed", time.Hour, corev1.ContainerStatus{Ready: true, State: running, RestartCount: 3, LastTerminationState: killed("Error", 137)}),
+		row("liveness-terminated", time.Hour, corev1.ContainerStatus{Ready: true, State: running, RestartCount: 1, LastTerminationState: killed("Error", 143)}),
+		row("oom-killed", time.Hour, corev1.ContainerStatus{Ready: true, State: running, RestartCount: 2, LastTerminationState: killed("OOMKilled", 137)}),
+		row("crashed", time.Hour, corev1.ContainerStatus{Ready: true, State: running, RestartCount: 2, LastTerminationState: killed("Error", 1)}),
+		row("readiness-failing", time.Minute, corev1.ContainerStatus{Ready: true, State: running}, corev1.ContainerStatus{Ready: false, State: running}),
+		row("long-unready", time.Hour, corev1.ContainerStatus{Ready: false, State: running}),
+		row("starting", time.Minute, corev1.ContainerStatus{Ready: false, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}),
+	}}
+	var names []string
+	for _, r := range filterProbeFailurePods(in, now).Rows {
+		names = append(names, r.Object.Object.(*corev1.Pod).Name)
+	}
+	require.Equal(t, []string{"liveness-killed", "liveness-terminated", "readiness-failing"}, names)
+}
+
+func TestFilterPodsByConditions(t *testing.T) {
+	row := func(name string, conds ...corev1.PodCondition) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.PodStatus{Conditions: conds},
+		}}}
+	}
+	cond := func(typ corev1.PodConditionType, status corev1.ConditionStatus) corev1.PodCondition {
+		return corev1.PodCondition{Type: typ, Status: status, Reason: "ignored"}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("unschedulable", cond(corev1.PodScheduled, corev1.ConditionFalse)),
+		row("running", cond(corev1.PodScheduled, corev1.ConditionTrue), cond(corev1.PodReady, corev1.ConditionTrue)),
+		row("not-ready", cond(corev1.PodScheduled, corev1.ConditionTrue), cond(corev1.PodReady, corev1.ConditionFalse)),
+		row("no-conditions"),
+	}}
+	names := func(t metav1.Table) []string {
+		var out []string
+		for _, r := range t.Rows {
+			out = append(out, r.Object.Object.(*corev1.Pod).Name)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{"unschedulable"},
+		names(filterPodsByConditions(in, []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse}})))
+	require.Equal(t, []string{"running", "not-ready"},
+		names(filterPodsByConditions(in, []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}})))
+	// multiple conditions are AND'ed
+	require.Equal(t, []string{"not-ready"}, names(filterPodsByConditions(in, []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+		{Type: corev1.PodReady, Status: corev1.ConditionFalse},
+	})))
+	require.Empty(t, names(filterPodsByConditions(in, []corev1.PodCondition{{Type: "DisruptionTarget", Status: corev1.ConditionTrue}})))
+}
+
+func TestPodFiltersMetadataOnly(t *testing.T) {
+	// the daemonset, owner, age and label filters only use the pods' metadata
+	require.True(t, podFilters{owner: "web", since: time.Hour, excludeLabels: labels.Everything()}.metadataOnly())
+	require.True(t, podFilters{}.metadataOnly())
+	require.False(t, podFilters{image: "nginx"}.metadataOnly())
+	require.False(t, podFilters{notReady: true}.metadataOnly())
+	require.False(t, podFilters{probeFailures: true}.metadataOnly())
+	require.False(t, podFilters{succeededMaxAge: time.Hour}.metadataOnly())
+	require.False(t, podFilters{conditions: []corev1.PodCondition{{Type: corev1.PodReady}}}.metadataOnly())
+}
+
+func TestFilterOldSucceededPods(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	row := func(name string, phase corev1.PodPhase, created, finished time.Duration) metav1.TableRow {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-created))},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if finished > 0 {
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(now.Add(-finished))},
+			}}}
+		}
+		return metav1.TableRow{Object: runtime.RawExtension{Object: pod}}
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		row("young-succeeded", corev1.PodSucceeded, 30*time.Minute, 10*time.Minute),
+		row("old-succeeded", corev1.PodSucceeded, 3*time.Hour, 2*time.Hour),
+		row("old-created-recently-completed", corev1.PodSucceeded, 3*time.Hour, 5*time.Minute),
+		row("old-succeeded-no-statuses", corev1.PodSucceeded, 2*time.Hour, 0),
+		row("old-running", corev1.PodRunning, 3*time.Hour, 0),
+		row("old-failed", corev1.PodFailed, 3*time.Hour, 2*time.Hour),
+	}}
+	var names []string
+	for _, r := range filterOldSucceededPods(in, now, time.Hour).Rows {
+		names = append(names, r.Object.Object.(*corev1.Pod).Name)
+	}
+	require.Equal(t, []string{"young-succeeded", "old-created-recently-completed", "old-running", "old-failed"}, names)
+}
+
+func TestFilterPodsByImage(t *testing.T) {
+	p1 := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:1.25"}}},
+	}
+	p2 := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p2"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Image: "redis:7"}},
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Image: "busybox:1.36"},
+			}},
+		},
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &p1}},
+		{Object: runtime.RawExtension{Object: &p2}},
+	}}
+
+	require.Equal(t, []metav1.TableRow{{Object: runtime.RawExtension{Object: &p1}}},
+		filterPodsByImage(in, "nginx", false).Rows)
+	require.Empty(t, filterPodsByImage(in, "busybox", false).Rows)
+	require.Equal(t, []metav1.TableRow{{Object: runtime.RawExtension{Object: &p2}}},
+		filterPodsByImage(in, "busybox", true).Rows)
+}
+
+func TestMatchedContainers(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", UID: "uid1"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "example.com/migrate:v2"}},
+			Containers: []corev1.Container{
+				{Name: "app", Image: "example.com/app:v1"},
+				{Name: "proxy", Image: "envoy:1.29"},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "example.com/debug"},
+			}},
+		},
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{{Object: runtime.RawExtension{Object: pod}}}}
+
+	// only the init container matches
+	require.Len(t, filterPodsByImage(in, "migrate", false).Rows, 1)
+	require.Equal(t, map[types.UID]string{"uid1": "init:migrate"}, matchedContainers(in, "migrate", false))
+
+	require.Equal(t, map[types.UID]string{"uid1": "init:migrate,app"}, matchedContainers(in, "example.com", false))
+	require.Equal(t, map[types.UID]string{"uid1": "init:migrate,app,ephemeral:debug"}, matchedContainers(in, "example.com", true))
+	require.Equal(t, map[types.UID]string{"uid1": ""}, matchedContainers(in, "redis", true))
+}
+
+func TestDedupePodRows(t *testing.T) {
+	row := func(ns, name, uid string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, UID: types.UID(uid)},
+		}}}
+	}
+	out := dedupePodRows(metav1.Table{Rows: []metav1.TableRow{
+		row("ns1", "a", "uid1"),
+		row("ns1", "b", "uid2"),
+		row("ns1", "a", "uid1"), // duplicate
+		row("ns2", "a", "uid3"),
+		row("ns1", "a", "uid4"), // recreated pod with the same name
+		row("ns1", "b", "uid2"), // duplicate
+	}})
+	require.Equal(t, []metav1.TableRow{
+		row("ns1", "a", "uid1"),
+		row("ns1", "b", "uid2"),
+		row("ns2", "a", "uid3"),
+		row("ns1", "a", "uid4"),
+	}, out.Rows)
+
+	require.Empty(t, dedupePodRows(metav1.Table{}).Rows)
+}
+
+func TestFilterPodsByOwner(t *testing.T) {
+	pod := func(name string, owners ...metav1.OwnerReference) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, OwnerReferences: owners},
+		}}}
+	}
+	names := func(t metav1.Table) []string {
+		var out []string
+		for _, r := range t.Rows {
+			out = append(out, r.Object.Object.(*corev1.Pod).Name)
+		}
+		return out
+	}
+	in := metav1.Table{Rows: []metav1.TableRow{
+		pod("web-7d9f8b6c5d-abcde", metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-7d9f8b6c5d"}),
+		pod("web-api-5c8d7f9b4-xyz12", metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-api-5c8d7f9b4"}),
+		pod("web-0", metav1.OwnerReference{Kind: "StatefulSet", Name: "web"}),
+		pod("web-job-abcde", metav1.OwnerReference{Kind: "Job", Name: "web-job"}),
+		pod("standalone"),
+	}}
+
+	t.Run("direct owner", func(t *testing.T) {
+		require.Equal(t, []string{"web-0"}, names(filterPodsByOwner(in, "web", "StatefulSet")))
+		require.Equal(t, []string{"web-job-abcde"}, names(filterPodsByOwner(in, "web-job", "job")))
+		require.Equal(t, []string{"web-api-5c8d7f9b4-xyz12"}, names(filterPodsByOwner(in, "web-api-5c8d7f9b4", "ReplicaSet")))
+	})
+	t.Run("deployment via replicaset prefix", func(t *testing.T) {
+		require.Equal(t, []string{"web-7d9f8b6c5d-abcde"}, names(filterPodsByOwner(in, "web", "Deployment")))
+		require.Equal(t, []string{"web-api-5c8d7f9b4-xyz12"}, names(filterPodsByOwner(in, "web-api", "Deployment")))
+	})
+	t.Run("any kind", func(t *testing.T) {
+		require.Equal(t, []string{"web-7d9f8b6c5d-abcde", "web-0"}, names(filterPodsByOwner(in, "web", "")))
+	})
+}
+
+func TestUserAgent(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		userAgents = map[string]string{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents[r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"List","apiVersion":"v1","items":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	query := func(t *testing.T, userAgent string) {
+		restCfg := &rest.Config{Host: srv.URL, QPS: -1, UserAgent: userAgent}
+		clientset, err := kubernetes.NewForConfig(restCfg)
+		require.NoError(t, err)
+		_, err = clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+
+		rc, err := makePodsRESTClient(func() (*rest.Config, error) { return rest.CopyConfig(restCfg), nil })
+		require.NoError(t, err)
+		require.NoError(t, rc.Get().Resource("pods").Do(context.Background()).Error())
+	}
+
+	query(t, userAgentOrDefault("audit-run-42"))
+	require.Equal(t, map[string]string{"/api/v1/nodes": "audit-run-42", "/api/v1/pods": "audit-run-42"}, userAgents)
+
+	query(t, userAgentOrDefault(""))
+	require.Equal(t, map[string]string{"/api/v1/nodes": "kubectl-pods_on/dev", "/api/v1/pods": "kubectl-pods_on/dev"}, userAgents)
+}
+
+func TestRunStageErrors(t *testing.T) {
+	opts := func() *options {
+		return &options{
+			applyLogFlags: func() (bool, error) { return false, nil },
+			colorMode:     colorNever,
+			groupBy:       "node",
+			countBy:       "node",
+			nodeColumn:    "first",
+		}
+	}
+
+	o := opts()
+	o.groupBy = "pod"
+	err := run(context.Background(), o, nil)
+	require.ErrorContains(t, err, `invalid --group-by value "pod"`)
+	require.Equal(t, stageInit, errorStage(err, stagePrint))
+
+	o = opts()
+	o.maxPods = -1
+	err = run(context.Background(), o, nil)
+	require.EqualError(t, err, "--max-pods must not be negative")
+	require.Equal(t, stageInit, errorStage(err, stagePrint))
+
+	o = opts()
+	o.serveAddr, o.contexts = ":8080", []string{"ctx1", "ctx2"}
+	err = run(context.Background(), o, nil)
+	require.ErrorContains(t, err, "--serve caches the pods of a single context")
+	require.Equal(t, stageInit, errorStage(err, stagePrint))
+}
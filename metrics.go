@@ -0,0 +1,53 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// runMetrics are the metrics of a run printed with --metrics.
+type runMetrics struct {
+	strategy      string // pod query strategy (or "auto" across contexts)
+	queryDuration time.Duration
+	podsMatched   int
+	stats         queryStats
+}
+
+// writeMetrics writes the metrics to w in the Prometheus text exposition
+// format, labeled by the query strategy.
+func writeMetrics(w io.Writer, m runMetrics) error {
+	labels := fmt.Sprintf("{strategy=%q}", m.strategy)
+	for _, metric := range []struct {
+		name, help, typ string
+		value           string
+	}{
+		{"pods_on_query_duration_seconds", "Time taken to query the pods.", "gauge",
+			strconv.FormatFloat(m.queryDuration.Seconds(), 'g', -1, 64)},
+		{"pods_on_pods_matched_total", "Number of pods matched on the queried nodes.", "counter",
+			strconv.Itoa(m.podsMatched)},
+		{"pods_on_api_pages_total", "Number of pod list pages fetched from the API server.", "counter",
+			strconv.Itoa(m.stats.pages)},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s%s %s\n",
+			metric.name, metric.help, metric.name, metric.typ, metric.name, labels, metric.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, writeMetrics(&b, runMetrics{
+		strategy:      string(queryPodPerNodeInParallel),
+		queryDuration: 1250 * time.Millisecond,
+		podsMatched:   42,
+		stats:         queryStats{pages: 3, podsRetrieved: 100},
+	}))
+	require.Equal(t, `# HELP pods_on_query_duration_seconds Time taken to query the pods.
+# TYPE pods_on_query_duration_seconds gauge
+pods_on_query_duration_seconds{strategy="by-node"} 1.25
+# HELP pods_on_pods_matched_total Number of pods matched on the queried nodes.
+# TYPE pods_on_pods_matched_total counter
+pods_on_pods_matched_total{strategy="by-node"} 42
+# HELP pods_on_api_pages_total Number of pod list pages fetched from the API server.
+# TYPE pods_on_api_pages_total counter
+pods_on_api_pages_total{strategy="by-node"} 3
+`, b.String())
+}
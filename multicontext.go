@@ -0,0 +1,422 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+)
+
+// contextQuery is the node resolution and pod query to run in a kubeconfig
+// context: the current context, or each of the --contexts.
+type contextQuery struct {
+	name       string // empty for the current context
+	restConfig func() (*rest.Config, error)
+	namespace  func() (string, error) // the namespace of --from-workload
+
+	selectors          []labels.Selector
+	nodeNames          []string
+	nodeIPs            []string
+	nodeFieldSelector  string
+	workloadKind       string
+	workloadName       string
+	shortNames         bool
+	strictNodes        bool
+	onlyNotReadyNodes  bool
+	allNodes           bool
+	includeUnscheduled bool
+	totalNodesHint     int
+	strategy           podQueryStrategy // chosen by the matched nodes if empty
+	numWorkers         int64
+	batchNodes         int
+	showProgress       bool
+	queryOpts          podQueryOpts
+}
+
+// logPrefix returns the prefix of the log messages about the context, empty
+// for the current context.
+func (q contextQuery) logPrefix() string {
+	if q.name == "" {
+		return ""
+	}
+	return fmt.Sprintf("context %q: ", q.name)
+}
+
+// contextTarget is a context with its nodes resolved and its pod query
+// strategy chosen.
+type contextTarget struct {
+	contextQuery
+	clientset           *kubernetes.Clientset
+	pods                podLister
+	conns               connStats
+	nodes               *nodeCache
+	matchedNodes        sets.Set[string]
+	heuristicTotalNodes int
+	explanation         string
+	matchSelectors      time.Duration
+}
+
+// contextClientConfig returns the client config of the given context in the
+// kubeconfig, with the overrides of the kubectl flags (e.g. --as, --user or
+// --request-timeout) applied like to the current context.
+func contextClientConfig(rawKubeCfg clientcmdapi.Config, contextName string, overrides *clientcmd.ConfigOverrides) clientcmd.ClientConfig {
+	contextOverrides := *overrides
+	contextOverrides.CurrentContext = contextName
+	return clientcmd.NewNonInteractiveClientConfig(rawKubeCfg, contextName, &contextOverrides, nil)
+}
+
+// resolveContexts resolves the nodes of each query concurrently. It returns
+// the contexts in the order of the queries, and the errors of the contexts
+// that failed (by context name) without aborting the others.
+func resolveContexts(ctx context.Context, queries []contextQuery) ([]*contextTarget, map[string]error) {
+	var (
+		targets = make([]*contextTarget, len(queries))
+		errs    = make(map[string]error)
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for i, q := range queries {
+		i, q := i, q
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t, err := resolveContext(ctx, q)
+			if err != nil {
+				mu.Lock()
+				errs[q.name] = err
+				mu.Unlock()
+				return
+			}
+			targets[i] = t
+		}()
+	}
+	wg.Wait()
+	return slices.DeleteFunc(targets, func(t *contextTarget) bool { return t == nil }), errs
+}
+
+// resolveContext resolves the nodes to query in the context, and chooses the
+// pod query strategy for them. The errors carry the stage they occurred in.
+func resolveContext(ctx context.Context, q contextQuery) (*contextTarget, error) {
+	restCfg, err := q.restConfig()
+	if err != nil {
+		return nil, stageErrorf(stageInit, "failed to get REST config: %w", err)
+	}
+	t := &contextTarget{contextQuery: q}
+	if t.clientset, err = kubernetes.NewForConfig(restCfg); err != nil {
+		return nil, stageErrorf(stageInit, "failed to create clientset: %w", err)
+	}
+	// reuse the REST config with the QPS/Burst settings applied
+	podsRestClient, err := makePodsRESTClient(func() (*rest.Config, error) {
+		cfg := rest.CopyConfig(restCfg)
+		cfg.Wrap(t.conns.wrap)
+		return cfg, nil
+	})
+	if err != nil {
+		return nil, stageErrorf(stageQuery, "failed to create REST client: %w", err)
+	}
+	t.pods = restPodLister{podsRestClient}
+
+	if q.workloadKind != "" {
+		namespace, err := q.namespace()
+		if err != nil {
+			return nil, stageErrorf(stageInit, "failed to get namespace: %w", err)
+		}
+		podSpec, err := getWorkloadPodSpec(ctx, t.clientset.AppsV1(), namespace, q.workloadKind, q.workloadName)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to get %s/%s: %w", q.workloadKind, q.workloadName, err)
+		}
+		workloadSelectors, err := podSpecNodeSelectors(podSpec)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to translate the node constraints of %s/%s: %w", q.workloadKind, q.workloadName, err)
+		}
+		klog.V(1).Infof("%snode selectors of %s/%s: %v", t.logPrefix(), q.workloadKind, q.workloadName, workloadSelectors)
+		// the selectors of the query are shared by the contexts
+		t.selectors = append(slices.Clip(q.selectors), workloadSelectors...)
+	}
+
+	t.nodes = newNodeCache(t.clientset.CoreV1().Nodes(), q.nodeFieldSelector, q.numWorkers, q.queryOpts.maxRetries)
+	if q.shortNames && len(q.nodeNames) > 0 {
+		allNodes, err := t.nodes.list(ctx)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to list nodes: %w", err)
+		}
+		if t.nodeNames, err = resolveShortNodeNames(q.nodeNames, sets.KeySet(allNodes)); err != nil {
+			return nil, stageErrorf(stageResolveNodes, "%w", err)
+		}
+		klog.V(1).Infof("%snode names after resolving short names: %v", t.logPrefix(), t.nodeNames)
+	}
+	t.matchedNodes = sets.New[string](t.nodeNames...)
+	if len(t.selectors) > 0 || q.nodeFieldSelector != "" {
+		klog.V(3).Infof("%sresolving node selectors: %v (field selector: %q)", t.logPrefix(), t.selectors, q.nodeFieldSelector)
+		listedNodes, err := t.nodes.list(ctx)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to resolve nodes by selectors: %w", err)
+		}
+		matchStart := time.Now()
+		t.matchedNodes = t.matchedNodes.Union(resolveNodeNames(ctx, listedNodes, t.selectors))
+		t.matchSelectors = time.Since(matchStart)
+		if t.heuristicTotalNodes, err = t.nodes.totalNodes(ctx); err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to count nodes: %w", err)
+		}
+	}
+	if len(q.nodeIPs) > 0 {
+		listedNodes, err := t.nodes.list(ctx)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to resolve nodes by IP: %w", err)
+		}
+		ipNodes, unknown := nodeNamesByInternalIP(listedNodes, q.nodeIPs)
+		if len(unknown) > 0 {
+			if q.strictNodes {
+				return nil, stageErrorf(stageResolveNodes, "no nodes found with internal IPs: %s", strings.Join(unknown, ", "))
+			}
+			klog.Warningf("%sno nodes found with internal IPs: %s", t.logPrefix(), strings.Join(unknown, ", "))
+		}
+		t.matchedNodes = t.matchedNodes.Union(ipNodes)
+	}
+	if q.strictNodes && len(t.nodeNames) > 0 {
+		allNodes, err := t.nodes.list(ctx)
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to list nodes: %w", err)
+		}
+		if unknown := unknownNodeNames(t.nodeNames, sets.KeySet(allNodes)); len(unknown) > 0 {
+			return nil, stageErrorf(stageResolveNodes, "nodes not found in the cluster: %s", strings.Join(unknown, ", "))
+		}
+	}
+	if q.onlyNotReadyNodes {
+		matched, err := t.nodes.get(ctx, sets.List(t.matchedNodes))
+		if err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to get nodes: %w", err)
+		}
+		notReady := notReadyNodeNames(matched)
+		klog.V(1).Infof("%s--only-notready-nodes: dropped %d of %d matched nodes that are Ready (or not found)", t.logPrefix(), t.matchedNodes.Len()-notReady.Len(), t.matchedNodes.Len())
+		t.matchedNodes = notReady
+	}
+	if t.nodes.listed && t.heuristicTotalNodes == 0 {
+		// the nodes were listed to resolve IPs, short names or --strict-nodes
+		if t.heuristicTotalNodes, err = t.nodes.totalNodes(ctx); err != nil {
+			return nil, stageErrorf(stageResolveNodes, "failed to count nodes: %w", err)
+		}
+	}
+	klog.V(3).Infof("%stotal nodes to query: %d", t.logPrefix(), t.matchedNodes.Len())
+	if t.matchedNodes.Len() == 0 {
+		klog.Warningf("%sno nodes matched the given selectors (%d nodes listed)", t.logPrefix(), t.heuristicTotalNodes)
+	}
+	t.heuristicTotalNodes = totalNodesOrHint(t.heuristicTotalNodes, q.totalNodesHint)
+
+	if t.strategy != "" {
+		t.explanation = fmt.Sprintf("strategy %s: set with --strategy", t.strategy)
+	}
+	if q.includeUnscheduled {
+		t.strategy = queryAllPods
+		t.explanation = fmt.Sprintf("strategy %s: --include-unscheduled is set, and unscheduled pods can't be queried by node", t.strategy)
+	}
+	if q.allNodes && t.strategy == "" {
+		t.strategy = queryAllPods
+		t.explanation = fmt.Sprintf("strategy %s: --all-nodes is set", t.strategy)
+	}
+	if t.strategy == "" {
+		t.strategy = chooseStrategy(t.heuristicTotalNodes, t.matchedNodes.Len())
+		_, t.explanation = explainStrategy(t.heuristicTotalNodes, t.matchedNodes.Len())
+		klog.V(1).Infof("%sbased on nodes matched to selectors (%d/%d), using query strategy: %q",
+			t.logPrefix(), t.matchedNodes.Len(), t.heuristicTotalNodes, t.strategy)
+	}
+	klog.V(1).Infof("%spod query strategy: %q", t.logPrefix(), t.strategy)
+	return t, nil
+}
+
+// queryPlan returns the plan of the pod query in the context for --dry-run.
+func (t *contextTarget) queryPlan() queryPlan {
+	return queryPlan{
+		strategy:            t.strategy,
+		nodeSelectors:       t.selectors,
+		matchedNodes:        sets.List(t.matchedNodes),
+		heuristicTotalNodes: t.heuristicTotalNodes,
+		numWorkers:          t.numWorkers,
+		batchNodes:          t.batchNodes,
+	}
+}
+
+// queryPods queries the pods on the matched nodes of the context.
+func (t *contextTarget) queryPods(ctx context.Context) (metav1.Table, queryStats, error) {
+	var (
+		resp  metav1.Table
+		stats queryStats
+		err   error
+	)
+	switch t.strategy {
+	case queryAllPods:
+		podNodes := t.matchedNodes
+		if t.includeUnscheduled {
+			// pods without a node have an empty spec.nodeName
+			podNodes = t.matchedNodes.Clone().Insert("")
+		}
+		resp, stats, err = findPodsByQueryingAllPods(ctx, t.pods, podNodes, t.queryOpts)
+	case queryPodPerNodeInParallel:
+		klog.V(1).Infof("%squerying list of pods on each node in parallel (workers: %d)", t.logPrefix(), t.numWorkers)
+		resp, stats, err = findPodsByQueryingNodesInParallel(ctx, t.pods, t.matchedNodes.UnsortedList(), t.numWorkers, t.batchNodes, t.queryOpts, t.showProgress)
+	default:
+		return resp, stats, fmt.Errorf("unknown pod query strategy: %q", t.strategy)
+	}
+	klog.V(1).Infof("%smade %d pod requests over %d new connections (protocols: %v)", t.logPrefix(), t.conns.requests.Load(), t.conns.newConns.Load(), t.conns.protocols())
+	if apierrors.IsForbidden(err) && t.queryOpts.namespace != "" {
+		return resp, stats, fmt.Errorf("failed to query pods from Kubernetes API (pods are listed in namespace %q): %w", t.queryOpts.namespace, err)
+	} else if apierrors.IsForbidden(err) {
+		return resp, stats, fmt.Errorf("failed to query pods from Kubernetes API (pods are listed across all namespaces, which requires permission to list pods cluster-wide): %w", err)
+	} else if err != nil {
+		return resp, stats, fmt.Errorf("failed to query pods from Kubernetes API: %w", err)
+	}
+	return resp, stats, nil
+}
+
+// queryContexts queries the pods in each context concurrently and merges the
+// results. It returns the context of each pod (by pod UID), and the errors of
+// the contexts that failed (by context name) without aborting the others.
+func queryContexts(ctx context.Context, targets []*contextTarget) (metav1.Table, map[types.UID]string, queryStats, map[string]error) {
+	var (
+		out         metav1.Table
+		podContexts = make(map[types.UID]string)
+		stats       queryStats
+		errs        = make(map[string]error)
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+	)
+	for _, t := range targets {
+		t := t
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, ctxStats, err := t.queryPods(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil && out.ColumnDefinitions != nil && len(out.ColumnDefinitions) != len(resp.ColumnDefinitions) {
+				err = fmt.Errorf("table columns (%d) don't match the other contexts' (%d)", len(resp.ColumnDefinitions), len(out.ColumnDefinitions))
+			}
+			if err != nil {
+				errs[t.name] = err
+				return
+			}
+			klog.V(1).Infof("%sfound %d pods", t.logPrefix(), len(resp.Rows))
+			stats.add(ctxStats)
+			if out.ColumnDefinitions == nil {
+				out.ColumnDefinitions = resp.ColumnDefinitions
+			}
+			for _, row := range resp.Rows {
+				podContexts[row.Object.Object.(*corev1.Pod).UID] = t.name
+			}
+			out.Rows = append(out.Rows, resp.Rows...)
+		}()
+	}
+	wg.Wait()
+	return out, podContexts, stats, errs
+}
+
+// contextsError returns the error of the current context, or an error listing
+// the errors of all the contexts if they all failed. If only some of the
+// contexts failed, it warns that the results are incomplete instead.
+func contextsError(action string, errs map[string]error, total int) error {
+	if err, ok := errs[""]; ok {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) < total {
+		klog.Warningf("failed to %s in %d of %d contexts, the results are incomplete:\n%s", action, len(errs), total, formatContextErrors(errs))
+		return nil
+	}
+	return fmt.Errorf("failed to %s in all contexts:\n%s", action, formatContextErrors(errs))
+}
+
+// formatContextErrors returns the errors of the failed contexts, sorted by
+// context name, one per line.
+func formatContextErrors(errs map[string]error) string {
+	var lines []string
+	for _, name := range sets.List(sets.KeySet(errs)) {
+		lines = append(lines, fmt.Sprintf("context %q: %v", name, errs[name]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// contextsStrategy returns the pod query strategy used in the contexts, or
+// "auto" if it differs between them.
+func contextsStrategy(targets []*contextTarget) string {
+	strategies := sets.New[podQueryStrategy]()
+	for _, t := range targets {
+		strategies.Insert(t.strategy)
+	}
+	if strategies.Len() != 1 {
+		return "auto"
+	}
+	return string(sets.List(strategies)[0])
+}
+
+// getContextNodes returns the matched nodes of the contexts, keyed by nodeKey.
+func getContextNodes(ctx context.Context, targets []*contextTarget) (map[string]*corev1.Node, error) {
+	out := make(map[string]*corev1.Node)
+	for _, t := range targets {
+		nodes, err := t.nodes.get(ctx, sets.List(t.matchedNodes))
+		if err != nil {
+			return nil, fmt.Errorf("%sfailed to get nodes: %w", t.logPrefix(), err)
+		}
+		for name, node := range nodes {
+			out[nodeKey(t.name, name)] = node
+		}
+	}
+	return out, nil
+}
+
+// lastContextPodEvents returns the last event of each of the pods (by pod
+// UID), listing the events in the namespaces of the pods in each context.
+// Failures only leave the last event column incomplete, so they're logged.
+func lastContextPodEvents(ctx context.Context, targets []*contextTarget, resp metav1.Table, podContexts map[types.UID]string) map[types.UID]string {
+	var events []corev1.Event
+	for _, t := range targets {
+		namespaces := sets.New[string]()
+		for _, row := range resp.Rows {
+			if pod := row.Object.Object.(*corev1.Pod); podContexts[pod.UID] == t.name {
+				namespaces.Insert(pod.Namespace)
+			}
+		}
+		if namespaces.Len() == 0 {
+			continue
+		}
+		contextEvents, forbidden, err := listPodEvents(ctx, t.clientset.CoreV1(), sets.List(namespaces), t.numWorkers)
+		if err != nil {
+			klog.Warningf("%sfailed to list pod events, the last event column will be incomplete: %v", t.logPrefix(), err)
+		}
+		if len(forbidden) > 0 {
+			klog.Warningf("%snot allowed to list events in %d namespace(s), the last event column will be empty for their pods: %s", t.logPrefix(), len(forbidden), strings.Join(forbidden, ", "))
+		}
+		events = append(events, contextEvents...)
+	}
+	return lastPodEvents(events)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/utils/ptr"
+)
+
+func TestContextClientConfig(t *testing.T) {
+	cfg := clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"c1": {Server: "https://c1.example.com"},
+			"c2": {Server: "https://c2.example.com"},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"u1": {Token: "t1"},
+			"u2": {Token: "t2"},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			"ctx1": {Cluster: "c1", AuthInfo: "u1", Namespace: "ns1"},
+			"ctx2": {Cluster: "c2", AuthInfo: "u2"},
+		},
+		CurrentContext: "ctx1",
+	}
+
+	flags := genericclioptions.NewConfigFlags(false)
+	restCfg, err := contextClientConfig(cfg, "ctx2", configOverrides(flags)).ClientConfig()
+	require.NoError(t, err)
+	require.Equal(t, "https://c2.example.com", restCfg.Host)
+	require.Equal(t, "t2", restCfg.BearerToken)
+	namespace, _, err := contextClientConfig(cfg, "ctx1", configOverrides(flags)).Namespace()
+	require.NoError(t, err)
+	require.Equal(t, "ns1", namespace)
+
+	// the kubectl flags apply to every context
+	flags.Impersonate = ptr.To("jane")
+	flags.ImpersonateGroup = &[]string{"devs"}
+	flags.BearerToken = ptr.To("override")
+	flags.Timeout = ptr.To("5s")
+	flags.Namespace = ptr.To("ns2")
+	restCfg, err = contextClientConfig(cfg, "ctx1", configOverrides(flags)).ClientConfig()
+	require.NoError(t, err)
+	require.Equal(t, "https://c1.example.com", restCfg.Host)
+	require.Equal(t, "override", restCfg.BearerToken)
+	require.Equal(t, 5*time.Second, restCfg.Timeout)
+	require.Equal(t, "jane", restCfg.Impersonate.UserName)
+	require.Equal(t, []string{"devs"}, restCfg.Impersonate.Groups)
+	namespace, _, err = contextClientConfig(cfg, "ctx1", configOverrides(flags)).Namespace()
+	require.NoError(t, err)
+	require.Equal(t, "ns2", namespace)
+
+	_, err = contextClientConfig(cfg, "ctx3", configOverrides(genericclioptions.NewConfigFlags(false))).ClientConfig()
+	require.Error(t, err)
+}
+
+func TestQueryContexts(t *testing.T) {
+	srv1, _ := fakePodsServer(t, []string{"node1", "node2"}, 2, nil)
+	srv2, _ := fakePodsServer(t, []string{"node1"}, 1, nil)
+	var queries []contextQuery
+	for _, c := range []struct{ name, host string }{{"ctx1", srv1.URL}, {"ctx2", srv2.URL}, {"ctx3", ""}} {
+		host := c.host
+		queries = append(queries, contextQuery{
+			name: c.name,
+			restConfig: func() (*rest.Config, error) {
+				if host == "" {
+					return nil, errors.New("no such context")
+				}
+				return &rest.Config{Host: host, QPS: -1}, nil
+			},
+			nodeNames:  []string{"node1", "node2"},
+			numWorkers: 2,
+		})
+	}
+
+	targets, errs := resolveContexts(context.Background(), queries)
+	require.Len(t, targets, 2)
+	require.Equal(t, stageInit, errorStage(errs["ctx3"], stageResolveNodes))
+	require.Equal(t, `context "ctx3": failed to get REST config: no such context`, formatContextErrors(errs))
+	require.NoError(t, contextsError("resolve nodes", errs, len(queries)))
+
+	resp, podContexts, stats, errs := queryContexts(context.Background(), targets)
+	require.Empty(t, errs)
+	require.Len(t, resp.Rows, 5)
+	require.Equal(t, 5, stats.podsRetrieved)
+
+	byContext := make(map[string][]string)
+	for _, row := range resp.Rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		byContext[podContexts[pod.UID]] = append(byContext[podContexts[pod.UID]], pod.Name)
+	}
+	require.ElementsMatch(t, []string{"node1-pod0", "node1-pod1", "node2-pod0", "node2-pod1"}, byContext["ctx1"])
+	require.Equal(t, []string{"node1-pod0"}, byContext["ctx2"])
+}
+
+func TestContextsError(t *testing.T) {
+	errCurrent := errors.New("forbidden")
+	require.Equal(t, errCurrent, contextsError("query pods", map[string]error{"": errCurrent}, 1))
+	require.NoError(t, contextsError("query pods", map[string]error{}, 2))
+	require.NoError(t, contextsError("query pods", map[string]error{"a": errCurrent}, 2))
+	require.EqualError(t, contextsError("query pods", map[string]error{"a": errCurrent, "b": errCurrent}, 2),
+		"failed to query pods in all contexts:\ncontext \"a\": forbidden\ncontext \"b\": forbidden")
+}
+
+func TestResolveContextNodeIPs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/nodes", r.URL.Path)
+		var list corev1.NodeList
+		for i := 1; i <= 3; i++ {
+			list.Items = append(list.Items, corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%d", i)},
+				Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+					{Type: corev1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i)},
+				}},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(list))
+	}))
+	t.Cleanup(srv.Close)
+
+	target, err := resolveContext(context.Background(), contextQuery{
+		restConfig: func() (*rest.Config, error) { return &rest.Config{Host: srv.URL, QPS: -1}, nil },
+		nodeIPs:    []string{"10.0.0.2", "10.0.0.3"},
+		numWorkers: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, sets.New("node2", "node3"), target.matchedNodes)
+	// the nodes listed to resolve the IPs are counted for the strategy
+	require.Equal(t, 3, target.heuristicTotalNodes)
+	require.EqualValues(t, queryAllPods, target.strategy)
+	require.Contains(t, target.explanation, "2 of 3 nodes matched")
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fatih/semgroup"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/klog/v2"
+)
+
+// nodeCache fetches nodes from the API at most once, and shares them between
+// the features that need them (resolving node selectors, counting the nodes,
+// node columns).
+type nodeCache struct {
+	client        typedcorev1.NodeInterface
+	fieldSelector string
+	numWorkers    int64
+	maxRetries    int
+
+	listed bool                    // whether the nodes were listed (narrowed by fieldSelector)
+	nodes  map[string]*corev1.Node // node name -> node
+	total  int                     // total number of nodes in the cluster (-1 if unknown)
+
+	fetchDuration time.Duration // total time spent fetching nodes from the API
+}
+
+func newNodeCache(client typedcorev1.NodeInterface, fieldSelector string, numWorkers int64, maxRetries int) *nodeCache {
+	return &nodeCache{
+		client:        client,
+		fieldSelector: fieldSelector,
+		numWorkers:    numWorkers,
+		maxRetries:    maxRetries,
+		nodes:         make(map[string]*corev1.Node),
+		total:         -1,
+	}
+}
+
+// list returns the nodes matching the field selector (or all the nodes in the
+// cluster). The nodes are listed from the API only on the first call.
+func (c *nodeCache) list(ctx context.Context) (map[string]*corev1.Node, error) {
+	if c.listed {
+		return c.nodes, nil
+	}
+	start := time.Now()
+	defer func() { c.fetchDuration += time.Since(start) }()
+
+	nodes := make(map[string]*corev1.Node)
+	// Use a pager to handle paginated node listing
+	p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		var list *corev1.NodeList
+		err := withRetries(ctx, c.maxRetries, func() (err error) {
+			list, err = c.client.List(ctx, opts)
+			return err
+		})
+		return list, err
+	})
+	err := p.EachListItem(ctx, metav1.ListOptions{
+		Limit:         500, // pagination!
+		FieldSelector: c.fieldSelector,
+	}, func(obj runtime.Object) error {
+		node := obj.(*corev1.Node)
+		nodes[node.Name] = node
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes in the cluster: %w", err)
+	}
+	klog.V(3).Infof("list nodes took %v (%d nodes)", time.Since(start), len(nodes))
+
+	c.nodes = nodes
+	c.listed = true
+	if c.fieldSelector == "" {
+		c.total = len(nodes)
+	}
+	return c.nodes, nil
+}
+
+// totalNodes returns the total number of nodes in the cluster, which is
+// derived from the node list unless it was narrowed by a field selector.
+func (c *nodeCache) totalNodes(ctx context.Context) (int, error) {
+	if c.total >= 0 {
+		return c.total, nil
+	}
+	if c.fieldSelector == "" {
+		nodes, err := c.list(ctx)
+		return len(nodes), err
+	}
+	start := time.Now()
+	n, err := countNodes(ctx, c.client, c.maxRetries)
+	c.fetchDuration += time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	c.total = n
+	return n, nil
+}
+
+// get returns the nodes with the given names. Nodes that are not in the
+// cache are fetched individually, unless all the nodes were already listed.
+// Nodes that don't exist are omitted from the result.
+func (c *nodeCache) get(ctx context.Context, names []string) (map[string]*corev1.Node, error) {
+	out := make(map[string]*corev1.Node, len(names))
+	var missing []string
+	for _, name := range names {
+		if node, ok := c.nodes[name]; ok {
+			out[name] = node
+		} else if !c.listed || c.fieldSelector != "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	start := time.Now()
+	fetched, err := getNodes(ctx, c.client, missing, c.numWorkers, c.maxRetries)
+	c.fetchDuration += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range fetched {
+		c.nodes[node.Name] = node
+		out[node.Name] = node
+	}
+	return out, nil
+}
+
+// countNodes returns the total number of nodes in the cluster. It relies on
+// the remaining item count of a single-item list call if the API server
+// provides it, and falls back to paginating through the node list otherwise.
+// The list calls are retried up to maxRetries times on transient errors.
+func countNodes(ctx context.Context, nodeClient typedcorev1.NodeInterface, maxRetries int) (int, error) {
+	listNodes := func(ctx context.Context, opts metav1.ListOptions) (list *corev1.NodeList, err error) {
+		err = withRetries(ctx, maxRetries, func() error {
+			list, err = nodeClient.List(ctx, opts)
+			return err
+		})
+		return list, err
+	}
+	list, err := listNodes(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if list.RemainingItemCount != nil {
+		return len(list.Items) + int(*list.RemainingItemCount), nil
+	}
+
+	var n int
+	p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return listNodes(ctx, opts)
+	})
+	err = p.EachListItem(ctx, metav1.ListOptions{Limit: 500}, func(runtime.Object) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list nodes in the cluster: %w", err)
+	}
+	return n, nil
+}
+
+// getNodes fetches the given nodes by name in parallel, retrying each up to
+// maxRetries times on transient errors. Nodes that don't exist are skipped.
+func getNodes(ctx context.Context, nodeClient typedcorev1.NodeInterface, nodeNames []string, numWorkers int64, maxRetries int) ([]*corev1.Node, error) {
+	var (
+		out []*corev1.Node
+		mu  sync.Mutex
+	)
+	g := semgroup.NewGroup(ctx, numWorkers)
+	for _, n := range nodeNames {
+		name := n
+		g.Go(func() error {
+			var node *corev1.Node
+			err := withRetries(ctx, maxRetries, func() (err error) {
+				node, err = nodeClient.Get(ctx, name, metav1.GetOptions{})
+				return err
+			})
+			if apierrors.IsNotFound(err) {
+				klog.Warningf("node %q not found", name)
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to get node %q: %w", name, err)
+			}
+			mu.Lock()
+			out = append(out, node)
+			mu.Unlock()
+			return nil
+		})
+	}
+	return out, g.Wait()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func countActions(client *fake.Clientset, verb string) int {
+	var n int
+	for _, action := range client.Actions() {
+		if action.Matches(verb, "nodes") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestNodeCache(t *testing.T) {
+	ctx := context.Background()
+	newClient := func() *fake.Clientset {
+		return fake.NewSimpleClientset(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node3"}},
+		)
+	}
+
+	t.Run("nodes are listed once", func(t *testing.T) {
+		client := newClient()
+		c := newNodeCache(client.CoreV1().Nodes(), "", 2, 0)
+
+		nodes, err := c.list(ctx)
+		require.NoError(t, err)
+		require.Equal(t, sets.New("node1", "node2", "node3"), sets.KeySet(nodes))
+
+		_, err = c.list(ctx)
+		require.NoError(t, err)
+		total, err := c.totalNodes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, total)
+		got, err := c.get(ctx, []string{"node1", "nonexistent"})
+		require.NoError(t, err)
+		require.Equal(t, sets.New("node1"), sets.KeySet(got))
+
+		require.Equal(t, 1, countActions(client, "list"))
+		require.Zero(t, countActions(client, "get"))
+	})
+
+	t.Run("nodes are fetched individually if not listed", func(t *testing.T) {
+		client := newClient()
+		c := newNodeCache(client.CoreV1().Nodes(), "", 2, 0)
+
+		got, err := c.get(ctx, []string{"node1", "node2", "nonexistent"})
+		require.NoError(t, err)
+		require.Equal(t, sets.New("node1", "node2"), sets.KeySet(got))
+		require.Equal(t, 3, countActions(client, "get"))
+
+		// cached nodes are not fetched again
+		_, err = c.get(ctx, []string{"node1"})
+		require.NoError(t, err)
+		require.Equal(t, 3, countActions(client, "get"))
+		require.Zero(t, countActions(client, "list"))
+	})
+
+	t.Run("total is counted separately with field selector", func(t *testing.T) {
+		client := newClient()
+		var lastListOpts []string
+		client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lastListOpts = append(lastListOpts, action.(k8stesting.ListAction).GetListRestrictions().Fields.String())
+			return false, nil, nil
+		})
+		c := newNodeCache(client.CoreV1().Nodes(), "metadata.name=node1", 2, 0)
+
+		_, err := c.list(ctx)
+		require.NoError(t, err)
+		total, err := c.totalNodes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, total)
+		// the fake client doesn't set remainingItemCount, so counting falls
+		// back to paginating through all nodes
+		require.Equal(t, []string{"metadata.name=node1", "", ""}, lastListOpts)
+
+		// total is memoized
+		_, err = c.totalNodes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, countActions(client, "list"))
+	})
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		defaultBackoff := retryBackoff
+		t.Cleanup(func() { retryBackoff = defaultBackoff })
+		retryBackoff.Duration = 0
+
+		client := newClient()
+		for _, verb := range []string{"get", "list"} {
+			var failed bool
+			client.PrependReactor(verb, "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if failed {
+					return false, nil, nil
+				}
+				failed = true
+				return true, nil, apierrors.NewTooManyRequests("slow down", 0)
+			})
+		}
+		c := newNodeCache(client.CoreV1().Nodes(), "metadata.name=node1", 1, 1)
+
+		got, err := c.get(ctx, []string{"node2"})
+		require.NoError(t, err)
+		require.Equal(t, sets.New("node2"), sets.KeySet(got))
+		require.Equal(t, 2, countActions(client, "get"))
+		total, err := c.totalNodes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, total)
+	})
+}
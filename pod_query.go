@@ -16,24 +16,59 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"io"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/semgroup"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	"k8s.io/kubectl/pkg/scheme"
 )
 
-func findPodsByQueryingAllPods(ctx context.Context, restClient *rest.RESTClient, nodeNames sets.Set[string]) (metav1.Table, error) {
-	resp, err := queryPods(ctx, restClient, podQueryOpts{})
+// queryStats contains counters collected while querying pods.
+type queryStats struct {
+	pages         int // number of API pages fetched
+	podsRetrieved int // number of pods returned by the API (before filtering)
+}
+
+func (s *queryStats) add(other queryStats) {
+	s.pages += other.pages
+	s.podsRetrieved += other.podsRetrieved
+}
+
+// podLister lists pods as a table, paginating through the results. It's
+// implemented by restPodLister, and by fakes in tests.
+type podLister interface {
+	Query(ctx context.Context, opts podQueryOpts) (metav1.Table, queryStats, error)
+}
+
+// restPodLister lists pods with the REST client.
+type restPodLister struct {
+	restClient *rest.RESTClient
+}
+
+func (l restPodLister) Query(ctx context.Context, opts podQueryOpts) (metav1.Table, queryStats, error) {
+	return queryPods(ctx, l.restClient, opts)
+}
+
+func findPodsByQueryingAllPods(ctx context.Context, pods podLister, nodeNames sets.Set[string], opts podQueryOpts) (metav1.Table, queryStats, error) {
+	resp, stats, err := pods.Query(ctx, opts)
 	if err != nil {
-		return metav1.Table{}, fmt.Errorf("failed to list pods: %w", err)
+		return metav1.Table{}, stats, fmt.Errorf("failed to list pods: %w", err)
 	}
 	var filtered []metav1.TableRow
 	for _, tableRow := range resp.Rows {
@@ -44,103 +79,357 @@ func findPodsByQueryingAllPods(ctx context.Context, restClient *rest.RESTClient,
 	resp.Rows = filtered
 
 	klog.V(2).Infof("matched %d pods on %d nodes", len(filtered), nodeNames.Len())
-	return resp, nil
+	return resp, stats, nil
 }
 
 // findPodsByQueryingNodesInParallel performs parallel queries to list pods by node.
-func findPodsByQueryingNodesInParallel(ctx context.Context, restClient *rest.RESTClient, nodeNames []string, numWorkers int64) (metav1.Table, error) {
+// If batchSize is positive, nodes are queried in batches of batchSize nodes,
+// and a batch is started only after the previous one completes.
+// If showProgress is set, the number of nodes queried is printed to stderr.
+func findPodsByQueryingNodesInParallel(ctx context.Context, pods podLister, nodeNames []string, numWorkers int64, batchSize int, opts podQueryOpts, showProgress bool) (metav1.Table, queryStats, error) {
 	var (
-		out metav1.Table
-		mu  sync.Mutex
+		out   metav1.Table
+		stats queryStats
+		mu    sync.Mutex
+		done  atomic.Int64
 	)
+	if showProgress {
+		stop := startProgress(os.Stderr, len(nodeNames), &done)
+		defer stop()
+	}
 
-	g := semgroup.NewGroup(ctx, numWorkers)
-	for _, n := range nodeNames {
-		node := n
-		g.Go(func() error {
-			resp, err := queryPods(ctx, restClient, podQueryOpts{fieldSelectorNodeName: node})
-			if err != nil {
-				return fmt.Errorf("failed to list pods on node %q: %w", node, err)
-			}
+	for _, batch := range batchNodeNames(nodeNames, batchSize) {
+		g := semgroup.NewGroup(ctx, numWorkers)
+		for _, n := range batch {
+			node := n
+			g.Go(func() error {
+				nodeOpts := opts
+				nodeOpts.fieldSelectorNodeName = node
+				resp, nodeStats, err := pods.Query(ctx, nodeOpts)
+				done.Add(1)
+				if err != nil {
+					return fmt.Errorf("failed to list pods on node %q: %w", node, err)
+				}
 
-			mu.Lock()
-			if out.Rows == nil {
-				out = resp
-			} else {
-				// append to the existing table
-				out.Rows = append(out.Rows, resp.Rows...)
+				mu.Lock()
+				stats.add(nodeStats)
+				if out.Rows == nil {
+					out = resp
+				} else {
+					// append to the existing table
+					out.Rows = append(out.Rows, resp.Rows...)
 
-				// pick the highest resource version
-				if strings.Compare(resp.ResourceVersion, out.ResourceVersion) > 0 {
-					out.ResourceVersion = resp.ResourceVersion
+					// pick the highest resource version
+					out.ResourceVersion = maxResourceVersion(out.ResourceVersion, resp.ResourceVersion)
 				}
+				mu.Unlock()
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return out, stats, err
+		}
+	}
+	return out, stats, nil
+}
+
+// maxResourceVersion returns the higher of the resource versions. Resource
+// versions are opaque, but in practice they're integers (which don't compare
+// correctly as strings, e.g. "9" > "10"), otherwise they're compared as
+// strings.
+func maxResourceVersion(a, b string) string {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		if y > x {
+			return b
+		}
+		return a
+	}
+	return max(a, b)
+}
+
+// batchNodeNames splits nodeNames into batches of at most size nodes (or a
+// single batch if size is not positive).
+func batchNodeNames(nodeNames []string, size int) [][]string {
+	if size <= 0 || size >= len(nodeNames) {
+		return [][]string{nodeNames}
+	}
+	var batches [][]string
+	for len(nodeNames) > size {
+		batches = append(batches, nodeNames[:size:size])
+		nodeNames = nodeNames[size:]
+	}
+	return append(batches, nodeNames)
+}
+
+// startProgress prints the number of nodes queried out of total to w
+// periodically (overwriting the same line) until the returned function is
+// called, which clears the line.
+func startProgress(w io.Writer, total int, done *atomic.Int64) (stop func()) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	stopCh := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(w, "\r\x1b[Kqueried %d/%d nodes", done.Load(), total)
+			case <-stopCh:
+				return
 			}
-			mu.Unlock()
-			return nil
-		})
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(stopCh)
+		<-finished
+		fmt.Fprint(w, "\r\x1b[K")
 	}
-	err := g.Wait()
-	return out, err
 }
 
+// minRowsPerParseWorker is the minimum number of rows decoded by each worker
+// in parsePods, so that small tables aren't split across goroutines.
+const minRowsPerParseWorker = 500
+
 // parsePods parses untyped pod object (RawExtension) in table rows into corev1.Pod.
-func parsePods(t *metav1.Table) error {
-	for i, row := range t.Rows {
+// Large tables are parsed in chunks by up to numWorkers goroutines.
+func parsePods(t *metav1.Table, numWorkers int) error {
+	numWorkers = max(1, min(numWorkers, len(t.Rows)/minRowsPerParseWorker))
+	if numWorkers == 1 {
+		return parsePodRows(t.Rows, 0)
+	}
+	chunkSize := (len(t.Rows) + numWorkers - 1) / numWorkers
+	g := semgroup.NewGroup(context.Background(), int64(numWorkers))
+	for start := 0; start < len(t.Rows); start += chunkSize {
+		start := start
+		end := min(start+chunkSize, len(t.Rows))
+		g.Go(func() error { return parsePodRows(t.Rows[start:end], start) })
+	}
+	return g.Wait()
+}
+
+// parsePodRows parses the pods in rows, which start at the given row index
+// of the table (for error messages).
+func parsePodRows(rows []metav1.TableRow, offset int) error {
+	for i, row := range rows {
 		if row.Object.Object != nil {
 			if _, ok := row.Object.Object.(*corev1.Pod); !ok {
-				return fmt.Errorf("unexpected object type in row %d: %T (expected corev1.Pod)", i, row.Object.Object)
+				return fmt.Errorf("unexpected object type in row %d: %T (expected corev1.Pod)", offset+i, row.Object.Object)
 			}
 		} else {
 			// use serializer to parse pod from Object.Raw
 			pod, _, err := scheme.Codecs.UniversalDeserializer().Decode(row.Object.Raw, nil, nil)
 			if err != nil {
-				return fmt.Errorf("failed to decode pod in row %d: %w", i, err)
+				return fmt.Errorf("failed to decode pod in row %d: %w", offset+i, err)
 			}
 			row.Object.Object = pod
-			t.Rows[i] = row
+			rows[i] = row
 		}
 	}
 	return nil
 }
 
+// parsePodMetadata parses the pod metadata (PartialObjectMetadata) in the
+// table rows into corev1.Pod objects with only their metadata and
+// spec.nodeName set, so they can be handled like full pods. The node name is
+// taken from the Node column of the table.
+func parsePodMetadata(t *metav1.Table) error {
+	nodeColumn := slices.IndexFunc(t.ColumnDefinitions, func(c metav1.TableColumnDefinition) bool {
+		return c.Name == "Node"
+	})
+	for i, row := range t.Rows {
+		if row.Object.Object != nil || row.Object.Raw == nil {
+			continue // e.g. the server responded with full pods
+		}
+		if nodeColumn < 0 || nodeColumn >= len(row.Cells) {
+			return fmt.Errorf("no Node column in row %d of the table", i)
+		}
+		var meta metav1.PartialObjectMetadata
+		if err := json.Unmarshal(row.Object.Raw, &meta); err != nil {
+			return fmt.Errorf("failed to decode pod metadata in row %d: %w", i, err)
+		}
+		nodeName, _ := row.Cells[nodeColumn].(string)
+		if nodeName == "<none>" {
+			nodeName = "" // unscheduled
+		}
+		t.Rows[i].Object = runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: meta.ObjectMeta,
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+		}}
+	}
+	return nil
+}
+
+// decodeTable decodes the list pods response into a table. Servers (or
+// proxies in front of them) that don't support the Table format respond with
+// a PodList instead, which is converted into a minimal table.
+func decodeTable(result rest.Result) (metav1.Table, error) {
+	raw, err := result.Raw()
+	if err != nil {
+		return metav1.Table{}, err
+	}
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return metav1.Table{}, err
+	}
+	if typeMeta.Kind == "PodList" {
+		klog.V(1).Info("server responded with a PodList instead of a Table, building the table client-side")
+		var list corev1.PodList
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return metav1.Table{}, err
+		}
+		return podListToTable(&list, time.Now()), nil
+	}
+	var tbl metav1.Table
+	return tbl, result.Into(&tbl)
+}
+
+// podListToTable builds a table with the default columns of kubectl get pods
+// (Name, Ready, Status, Restarts, Age) from the pod list.
+func podListToTable(list *corev1.PodList, now time.Time) metav1.Table {
+	out := metav1.Table{
+		ListMeta: list.ListMeta,
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name"},
+			{Name: "Ready", Type: "string"},
+			{Name: "Status", Type: "string"},
+			{Name: "Restarts", Type: "string"},
+			{Name: "Age", Type: "string"},
+		},
+	}
+	for i := range list.Items {
+		pod := &list.Items[i]
+		var ready, restarts int
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Ready {
+				ready++
+			}
+			restarts += int(cs.RestartCount)
+		}
+		status := string(pod.Status.Phase)
+		if pod.Status.Reason != "" {
+			status = pod.Status.Reason
+		}
+		if pod.DeletionTimestamp != nil {
+			status = "Terminating"
+		}
+		out.Rows = append(out.Rows, metav1.TableRow{
+			Cells: []interface{}{
+				pod.Name,
+				fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers)),
+				status,
+				strconv.Itoa(restarts),
+				duration.HumanDuration(now.Sub(pod.CreationTimestamp.Time)),
+			},
+			Object: runtime.RawExtension{Object: pod},
+		})
+	}
+	return out
+}
+
 type podQueryOpts struct {
 	fieldSelectorNodeName string
+
+	// namespace lists only the pods in the namespace (all namespaces if empty)
+	namespace string
+
+	// labelSelector selects the pods by their labels on the server side
+	labelSelector string
+
+	// useWatchCache serves the list from the apiserver's watch cache
+	// (resourceVersion=0), which is faster but may be slightly stale.
+	useWatchCache bool
+
+	// resourceVersion lists the pods at the given resource version. As the
+	// list is paginated, the pods are read exactly at that version (or the
+	// request fails if it's too old).
+	resourceVersion string
+
+	// maxRetries is the number of times to retry a page on transient errors
+	maxRetries int
+
+	// metadataOnly requests only the metadata of the pods in the table
+	// (includeObject=Metadata), which makes the responses smaller. The pods in
+	// the returned table only have their metadata and spec.nodeName (from the
+	// table's Node column) set.
+	metadataOnly bool
 }
 
-func queryPods(ctx context.Context, restClient *rest.RESTClient, opts podQueryOpts) (metav1.Table, error) {
+// includeObject returns the includeObject parameter of the pod queries.
+func (o podQueryOpts) includeObject() metav1.IncludeObjectPolicy {
+	if o.metadataOnly {
+		return metav1.IncludeMetadata
+	}
+	return metav1.IncludeObject
+}
+
+// validateResourceVersion checks the --resource-version value, which must be
+// a number, and can't be combined with --use-cache (which lists at "0").
+func validateResourceVersion(resourceVersion string, useWatchCache bool) error {
+	if resourceVersion == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(resourceVersion, 10, 64); err != nil {
+		return fmt.Errorf("resource version %q is not a number", resourceVersion)
+	}
+	if useWatchCache {
+		return errors.New("--use-cache can't be used with --resource-version")
+	}
+	return nil
+}
+
+func queryPods(ctx context.Context, restClient *rest.RESTClient, opts podQueryOpts) (metav1.Table, queryStats, error) {
 	start := time.Now()
 	var tableResp metav1.Table
+	var stats queryStats
 	var continueToken string
 	var page int
 	for {
 		pageStart := time.Now()
 		var resp metav1.Table
 		req := restClient.Get().
+			Namespace(opts.namespace).
 			Resource("pods").
 			SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json").
-			Param("includeObject", string(metav1.IncludeObject)).
+			Param("includeObject", string(opts.includeObject())).
 			Param("limit", "1000")
 		if opts.fieldSelectorNodeName != "" {
 			req = req.Param("fieldSelector", "spec.nodeName="+opts.fieldSelectorNodeName)
 		}
+		if opts.labelSelector != "" {
+			req = req.Param("labelSelector", opts.labelSelector)
+		}
 		if continueToken != "" {
 			req = req.Param("continue", continueToken)
+		} else if opts.resourceVersion != "" {
+			req = req.Param("resourceVersion", opts.resourceVersion)
+		} else if opts.useWatchCache {
+			req = req.Param("resourceVersion", "0")
 		}
 
-		result := req.Do(ctx)
-		if err := result.Error(); err != nil {
-			return metav1.Table{}, fmt.Errorf("failed to list pods from kubernetes api: %w", err)
+		var result rest.Result
+		err := withRetries(ctx, opts.maxRetries, func() error {
+			result = req.Do(ctx)
+			return result.Error()
+		})
+		if err != nil {
+			return metav1.Table{}, stats, fmt.Errorf("failed to list pods from kubernetes api: %w", err)
 		}
-		if err := result.Into(&resp); err != nil {
-			return metav1.Table{}, fmt.Errorf("failed to unmarshal list pods response into metav1.Table: %w", err)
+		resp, err = decodeTable(result)
+		if err != nil {
+			return metav1.Table{}, stats, fmt.Errorf("failed to unmarshal list pods response into metav1.Table: %w", err)
 		}
+		stats.pages++
+		stats.podsRetrieved += len(resp.Rows)
 		klog.V(3).Infof("page %d: listed %d pods (took %v)", page, len(resp.Rows), time.Since(pageStart).Truncate(time.Millisecond))
 
 		if continueToken == "" {
 			tableResp = resp
 		} else {
 			tableResp.Rows = append(tableResp.Rows, resp.Rows...) // append to the existing table
-			tableResp.ResourceVersion = max(tableResp.ResourceVersion, resp.ResourceVersion)
+			tableResp.ResourceVersion = maxResourceVersion(tableResp.ResourceVersion, resp.ResourceVersion)
 		}
 
 		if resp.Continue == "" {
@@ -153,10 +442,15 @@ func queryPods(ctx context.Context, restClient *rest.RESTClient, opts podQueryOp
 	}
 
 	klog.V(1).Infof("listed pods, took %v (found %d pods)", time.Since(start).Truncate(time.Millisecond), len(tableResp.Rows))
+	if opts.metadataOnly {
+		if err := parsePodMetadata(&tableResp); err != nil {
+			return metav1.Table{}, stats, fmt.Errorf("failed to parse pod metadata in the table response: %w", err)
+		}
+	}
 	// parse raw ([]byte) pod objects into corev1.Pod
-	if err := parsePods(&tableResp); err != nil {
-		return metav1.Table{}, fmt.Errorf("failed to parse pods in the table response: %w", err)
+	if err := parsePods(&tableResp, goruntime.GOMAXPROCS(0)); err != nil {
+		return metav1.Table{}, stats, fmt.Errorf("failed to parse pods in the table response: %w", err)
 	}
 
-	return tableResp, nil
+	return tableResp, stats, nil
 }
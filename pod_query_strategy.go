@@ -14,7 +14,13 @@
 
 package main
 
-import "k8s.io/klog/v2"
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
 
 type podQueryStrategy string
 
@@ -23,7 +29,41 @@ const (
 	queryAllPods                               = "all-pods"
 )
 
+// validateStrategy returns an error if s is not a known strategy (or empty,
+// to choose automatically).
+func validateStrategy(s podQueryStrategy) error {
+	switch s {
+	case "", queryPodPerNodeInParallel, queryAllPods:
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q (expected %s or %s)", s, queryPodPerNodeInParallel, queryAllPods)
+}
+
+// strategyRatioThreshold is the fraction of the cluster's nodes below which
+// pods are queried by node in parallel, otherwise all pods in the cluster are
+// queried and filtered client-side.
+const strategyRatioThreshold = 0.25
+
+// totalNodesOrHint returns the number of nodes listed in the cluster, or the
+// --total-nodes-hint if nodes weren't listed, for choosing the strategy.
+func totalNodesOrHint(listedTotal, hint int) int {
+	if listedTotal == 0 {
+		return hint
+	}
+	return listedTotal
+}
+
 func chooseStrategy(heuristicTotalNodes, matchedNodes int) podQueryStrategy {
+	strategy, _ := explainStrategy(heuristicTotalNodes, matchedNodes)
+	if strategy == queryAllPods {
+		klog.Infof("FYI: node selector matched %d nodes, resorting to querying all pods in the cluster, and filtering them client-side (slow & expensive query in large clusters!)", matchedNodes)
+	}
+	return strategy
+}
+
+// explainStrategy chooses the strategy to query pods, and returns a
+// human-readable explanation of the decision (for --explain).
+func explainStrategy(heuristicTotalNodes, matchedNodes int) (podQueryStrategy, string) {
 	// There's no perfect formula to determine the best strategy, as it depends on:
 	//
 	// * The number of pods in the cluster (–which we don't know until we query all pods)
@@ -49,24 +89,63 @@ func chooseStrategy(heuristicTotalNodes, matchedNodes int) podQueryStrategy {
 	//     - "get pods by node in parallel" workers=20: 9s.
 
 	if matchedNodes == 1 { // single node: never need to query all pods in parallel
-		return queryPodPerNodeInParallel
+		return queryPodPerNodeInParallel, fmt.Sprintf("strategy %s: a single node matched, so querying its pods is cheaper than listing all pods in the cluster", queryPodPerNodeInParallel)
 	}
 
 	if heuristicTotalNodes == 0 {
 		// we didn't query nodes by selectors (so we don't know the total number of nodes)
 		// which means user probably specified "a few nodes"
-		return queryPodPerNodeInParallel
+		return queryPodPerNodeInParallel, fmt.Sprintf("strategy %s: %d nodes matched, and the total number of nodes is unknown (only node names were specified, so nodes were not listed); assuming a few nodes, querying their pods is cheaper than listing all pods in the cluster", queryPodPerNodeInParallel, matchedNodes)
 	}
 
 	// If the number of matched nodes is less than N% of the cluster, query pods by node in parallel.
 	// Otherwise, query all pods in the cluster.
-	var magicRatio = 0.25
-
 	ratio := float64(matchedNodes) / float64(heuristicTotalNodes)
-	if ratio < magicRatio {
-		return queryPodPerNodeInParallel
+	if ratio < strategyRatioThreshold {
+		return queryPodPerNodeInParallel, fmt.Sprintf("strategy %s: %d of %d nodes matched (%.1f%%), below the %.0f%% threshold, so querying the pods of each matched node in parallel is cheaper than listing all pods in the cluster",
+			queryPodPerNodeInParallel, matchedNodes, heuristicTotalNodes, ratio*100, strategyRatioThreshold*100)
+	}
+	return queryAllPods, fmt.Sprintf("strategy %s: %d of %d nodes matched (%.1f%%), at or above the %.0f%% threshold, so listing all pods in the cluster once and filtering them client-side is cheaper than a query per matched node",
+		queryAllPods, matchedNodes, heuristicTotalNodes, ratio*100, strategyRatioThreshold*100)
+}
+
+// queryPlan describes how pods will be queried, used for printing in dry-run
+// mode.
+type queryPlan struct {
+	strategy            podQueryStrategy
+	nodeSelectors       []labels.Selector
+	matchedNodes        []string
+	heuristicTotalNodes int
+	numWorkers          int64
+	batchNodes          int
+}
+
+// formatQueryPlan returns a human-readable description of the query plan.
+func formatQueryPlan(plan queryPlan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "strategy:       %s\n", plan.strategy)
+	fmt.Fprintf(&b, "matched nodes:  %d\n", len(plan.matchedNodes))
+	if plan.heuristicTotalNodes > 0 {
+		fmt.Fprintf(&b, "total nodes:    %d\n", plan.heuristicTotalNodes)
 	} else {
-		klog.Infof("FYI: node selector matched %d nodes, resorting to querying all pods in the cluster, and filtering them client-side (slow & expensive query in large clusters!)", matchedNodes)
-		return queryAllPods
+		fmt.Fprintf(&b, "total nodes:    unknown (nodes were not listed)\n")
+	}
+	if len(plan.nodeSelectors) > 0 {
+		var sels []string
+		for _, sel := range plan.nodeSelectors {
+			sels = append(sels, fmt.Sprintf("%q", sel.String()))
+		}
+		fmt.Fprintf(&b, "node selectors: %s\n", strings.Join(sels, ", "))
+	}
+	switch plan.strategy {
+	case queryPodPerNodeInParallel:
+		fmt.Fprintf(&b, "workers:        %d\n", plan.numWorkers)
+		if plan.batchNodes > 0 {
+			fmt.Fprintf(&b, "batches:        %d (%d nodes each)\n", len(batchNodeNames(plan.matchedNodes, plan.batchNodes)), plan.batchNodes)
+		}
+		fmt.Fprintf(&b, "pod queries:    %d (fieldSelector=spec.nodeName=<node>)\n", len(plan.matchedNodes))
+	case queryAllPods:
+		fmt.Fprintf(&b, "pod queries:    1 (all pods in the cluster, filtered client-side)\n")
 	}
+	return b.String()
 }
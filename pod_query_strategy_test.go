@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestFormatQueryPlan(t *testing.T) {
+	t.Run("by-node with selectors", func(t *testing.T) {
+		out := formatQueryPlan(queryPlan{
+			strategy:            queryPodPerNodeInParallel,
+			nodeSelectors:       []labels.Selector{labels.SelectorFromSet(labels.Set{"pool": "general"})},
+			matchedNodes:        []string{"node1", "node2"},
+			heuristicTotalNodes: 10,
+			numWorkers:          20,
+		})
+		require.Equal(t, `strategy:       by-node
+matched nodes:  2
+total nodes:    10
+node selectors: "pool=general"
+workers:        20
+pod queries:    2 (fieldSelector=spec.nodeName=<node>)
+`, out)
+	})
+	t.Run("all-pods with node names only", func(t *testing.T) {
+		out := formatQueryPlan(queryPlan{
+			strategy:     queryAllPods,
+			matchedNodes: []string{"node1"},
+		})
+		require.Equal(t, `strategy:       all-pods
+matched nodes:  1
+total nodes:    unknown (nodes were not listed)
+pod queries:    1 (all pods in the cluster, filtered client-side)
+`, out)
+	})
+}
+
+func TestValidateStrategy(t *testing.T) {
+	for _, s := range []podQueryStrategy{"", queryPodPerNodeInParallel, queryAllPods} {
+		require.NoError(t, validateStrategy(s))
+	}
+	require.ErrorContains(t, validateStrategy("by-pod"), `unknown strategy "by-pod"`)
+}
+
+func TestExplainStrategy(t *testing.T) {
+	tests := []struct {
+		name                string
+		heuristicTotalNodes int
+		matchedNodes        int
+		want                podQueryStrategy
+		explanation         string
+	}{
+		{"single node", 200, 1, queryPodPerNodeInParallel,
+			"strategy by-node: a single node matched, so querying its pods is cheaper than listing all pods in the cluster"},
+		{"nodes not listed", 0, 3, queryPodPerNodeInParallel,
+			"strategy by-node: 3 nodes matched, and the total number of nodes is unknown (only node names were specified, so nodes were not listed); assuming a few nodes, querying their pods is cheaper than listing all pods in the cluster"},
+		{"below threshold", 200, 16, queryPodPerNodeInParallel,
+			"strategy by-node: 16 of 200 nodes matched (8.0%), below the 25% threshold, so querying the pods of each matched node in parallel is cheaper than listing all pods in the cluster"},
+		{"at threshold", 200, 50, queryAllPods,
+			"strategy all-pods: 50 of 200 nodes matched (25.0%), at or above the 25% threshold, so listing all pods in the cluster once and filtering them client-side is cheaper than a query per matched node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, explanation := explainStrategy(tt.heuristicTotalNodes, tt.matchedNodes)
+			require.Equal(t, tt.want, strategy)
+			require.Equal(t, tt.explanation, explanation)
+			require.Equal(t, tt.want, chooseStrategy(tt.heuristicTotalNodes, tt.matchedNodes))
+		})
+	}
+}
+
+func TestTotalNodesOrHint(t *testing.T) {
+	// only node names given: without a hint, the strategy is always by-node
+	require.Equal(t, queryPodPerNodeInParallel, chooseStrategy(totalNodesOrHint(0, 0), 5))
+	// with a hint of a small cluster, the ratio picks all-pods
+	require.Equal(t, 10, totalNodesOrHint(0, 10))
+	require.EqualValues(t, queryAllPods, chooseStrategy(totalNodesOrHint(0, 10), 5))
+	require.Equal(t, queryPodPerNodeInParallel, chooseStrategy(totalNodesOrHint(0, 100), 5))
+	// the listed number of nodes takes precedence over the hint
+	require.Equal(t, 200, totalNodesOrHint(200, 10))
+}
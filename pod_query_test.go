@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/rest"
+)
+
+// fakePodsServer serves pod list requests as tables, with podsPerNode pods
+// on each of the given nodes. It fails requests for nodes in failNodes.
+func fakePodsServer(t testing.TB, nodes []string, podsPerNode int, failNodes sets.Set[string]) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		node := strings.TrimPrefix(r.URL.Query().Get("fieldSelector"), "spec.nodeName=")
+		if failNodes.Has(node) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		tbl := metav1.Table{
+			TypeMeta:          metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"},
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}},
+		}
+		for _, n := range nodes {
+			if node != "" && n != node {
+				continue
+			}
+			for i := 0; i < podsPerNode; i++ {
+				pod := &corev1.Pod{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-pod%d", n, i), Namespace: "default", UID: uuid.NewUUID()},
+					Spec:       corev1.PodSpec{NodeName: n},
+				}
+				raw, err := json.Marshal(pod)
+				require.NoError(t, err)
+				tbl.Rows = append(tbl.Rows, metav1.TableRow{Cells: []interface{}{pod.Name}, Object: runtime.RawExtension{Raw: raw}})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(tbl))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func fakePodsRESTClient(t testing.TB, srv *httptest.Server) *rest.RESTClient {
+	// disable client-side rate limiting
+	rc, err := makePodsRESTClient(func() (*rest.Config, error) { return &rest.Config{Host: srv.URL, QPS: -1}, nil })
+	require.NoError(t, err)
+	return rc
+}
+
+// fakePodLister serves the pods of each node (all pods if the query isn't
+// by node) from memory.
+type fakePodLister struct {
+	pods             map[string][]string // node name -> pod names
+	resourceVersions map[string]string   // node name -> resource version
+	failNodes        sets.Set[string]
+}
+
+func (l fakePodLister) Query(_ context.Context, opts podQueryOpts) (metav1.Table, queryStats, error) {
+	if l.failNodes.Has(opts.fieldSelectorNodeName) {
+		return metav1.Table{}, queryStats{pages: 1}, errors.New("internal error")
+	}
+	var out metav1.Table
+	out.ResourceVersion = l.resourceVersions[opts.fieldSelectorNodeName]
+	for node, names := range l.pods {
+		if opts.fieldSelectorNodeName != "" && node != opts.fieldSelectorNodeName {
+			continue
+		}
+		for _, name := range names {
+			out.Rows = append(out.Rows, metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec:       corev1.PodSpec{NodeName: node},
+			}}})
+		}
+	}
+	return out, queryStats{pages: 1, podsRetrieved: len(out.Rows)}, nil
+}
+
+func podRowNames(t metav1.Table) []string {
+	var out []string
+	for _, row := range t.Rows {
+		out = append(out, row.Object.Object.(*corev1.Pod).Name)
+	}
+	return out
+}
+
+func testNodeNames(n int) []string {
+	var nodes []string
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, fmt.Sprintf("node%d", i))
+	}
+	return nodes
+}
+
+func TestBatchNodeNames(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e"}
+	require.Equal(t, [][]string{nodes}, batchNodeNames(nodes, 0))
+	require.Equal(t, [][]string{nodes}, batchNodeNames(nodes, 5))
+	require.Equal(t, [][]string{nodes}, batchNodeNames(nodes, 10))
+	require.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batchNodeNames(nodes, 2))
+	require.Equal(t, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}, batchNodeNames(nodes, 1))
+}
+
+func TestFindPodsByQueryingNodesInParallel(t *testing.T) {
+	nodes := testNodeNames(10)
+	srv, requests := fakePodsServer(t, nodes, 3, nil)
+	rc := fakePodsRESTClient(t, srv)
+
+	for _, batchSize := range []int{0, 3} {
+		requests.Store(0)
+		out, stats, err := findPodsByQueryingNodesInParallel(context.Background(), restPodLister{rc}, nodes, 4, batchSize, podQueryOpts{}, false)
+		require.NoError(t, err, "batch=%d", batchSize)
+		require.Len(t, out.Rows, 30, "batch=%d", batchSize)
+		require.Equal(t, 30, stats.podsRetrieved)
+		require.EqualValues(t, 10, requests.Load())
+	}
+
+	t.Run("failed batch stops later batches", func(t *testing.T) {
+		srv, requests := fakePodsServer(t, nodes, 1, sets.New("node0"))
+		_, _, err := findPodsByQueryingNodesInParallel(context.Background(), restPodLister{fakePodsRESTClient(t, srv)}, nodes, 4, 2, podQueryOpts{}, false)
+		require.ErrorContains(t, err, `"node0"`)
+		require.EqualValues(t, 2, requests.Load())
+	})
+}
+
+func TestFindPodsMerge(t *testing.T) {
+	lister := fakePodLister{
+		pods: map[string][]string{
+			"node1": {"a", "b"},
+			"node2": {"c"},
+			"node3": {"d"},
+			"node4": nil,
+		},
+		resourceVersions: map[string]string{"": "12", "node1": "9", "node2": "10", "node3": "8"},
+	}
+
+	t.Run("by node", func(t *testing.T) {
+		out, stats, err := findPodsByQueryingNodesInParallel(context.Background(), lister, []string{"node1", "node2", "node3", "node4"}, 2, 0, podQueryOpts{}, false)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b", "c", "d"}, podRowNames(out))
+		require.Equal(t, "10", out.ResourceVersion, "highest resource version (compared as integers)")
+		require.Equal(t, queryStats{pages: 4, podsRetrieved: 4}, stats)
+	})
+	t.Run("by node failure", func(t *testing.T) {
+		failing := lister
+		failing.failNodes = sets.New("node2")
+		_, _, err := findPodsByQueryingNodesInParallel(context.Background(), failing, []string{"node1", "node2"}, 2, 0, podQueryOpts{}, false)
+		require.ErrorContains(t, err, `failed to list pods on node "node2": internal error`)
+	})
+	t.Run("all pods filtered by node", func(t *testing.T) {
+		out, stats, err := findPodsByQueryingAllPods(context.Background(), lister, sets.New("node1", "node3"), podQueryOpts{})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b", "d"}, podRowNames(out))
+		require.Equal(t, "12", out.ResourceVersion)
+		require.Equal(t, queryStats{pages: 1, podsRetrieved: 4}, stats)
+	})
+}
+
+func TestNodeNameInPodListOutput(t *testing.T) {
+	nodes := testNodeNames(4)
+	srv, _ := fakePodsServer(t, nodes, 2, nil)
+	rc := restPodLister{fakePodsRESTClient(t, srv)}
+	matched := []string{"node1", "node3"}
+
+	for _, strategy := range []podQueryStrategy{queryPodPerNodeInParallel, queryAllPods} {
+		t.Run(string(strategy), func(t *testing.T) {
+			var (
+				resp metav1.Table
+				err  error
+			)
+			if strategy == queryAllPods {
+				resp, _, err = findPodsByQueryingAllPods(context.Background(), rc, sets.New(matched...), podQueryOpts{})
+			} else {
+				resp, _, err = findPodsByQueryingNodesInParallel(context.Background(), rc, matched, 2, 0, podQueryOpts{}, false)
+			}
+			require.NoError(t, err)
+			require.Len(t, resp.Rows, 4)
+
+			// the Node column of the table output and spec.nodeName of the
+			// json/yaml output are the same
+			nodeColumn := make(map[string]string)
+			for _, row := range enhanceTable(resp, tableOpts{}).Rows {
+				nodeColumn[row.Object.Object.(*corev1.Pod).Name] = row.Cells[0].(string)
+			}
+			list := toPodList(resp, podListOpts{})
+			require.Len(t, list.Items, 4)
+			for _, pod := range list.Items {
+				require.Contains(t, matched, pod.Spec.NodeName)
+				require.True(t, strings.HasPrefix(pod.Name, pod.Spec.NodeName+"-"), pod.Name)
+				require.Equal(t, nodeColumn[pod.Name], pod.Spec.NodeName)
+			}
+		})
+	}
+}
+
+func TestQueryPodsResourceVersion(t *testing.T) {
+	var resourceVersions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resourceVersions = append(resourceVersions, r.URL.Query().Get("resourceVersion"))
+		tbl := metav1.Table{TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"}}
+		if r.URL.Query().Get("continue") == "" {
+			tbl.Continue = "page2"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(tbl))
+	}))
+	t.Cleanup(srv.Close)
+	rc := fakePodsRESTClient(t, srv)
+
+	for _, tt := range []struct {
+		opts podQueryOpts
+		want []string
+	}{
+		{podQueryOpts{}, []string{"", ""}},
+		{podQueryOpts{useWatchCache: true}, []string{"0", ""}},
+		{podQueryOpts{resourceVersion: "12345"}, []string{"12345", ""}}, // not allowed with continue
+	} {
+		resourceVersions = nil
+		_, _, err := queryPods(context.Background(), rc, tt.opts)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, resourceVersions, "%+v", tt.opts)
+	}
+}
+
+func TestQueryPodsLabelSelector(t *testing.T) {
+	var labelSelectors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labelSelectors = append(labelSelectors, r.URL.Query().Get("labelSelector"))
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(metav1.Table{TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"}}))
+	}))
+	t.Cleanup(srv.Close)
+	rc := fakePodsRESTClient(t, srv)
+
+	_, _, err := queryPods(context.Background(), rc, podQueryOpts{labelSelector: "app=web,tier!=db"})
+	require.NoError(t, err)
+	_, _, err = queryPods(context.Background(), rc, podQueryOpts{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"app=web,tier!=db", ""}, labelSelectors)
+}
+
+func TestQueryPodsNamespace(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(metav1.Table{TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"}}))
+	}))
+	t.Cleanup(srv.Close)
+	rc := fakePodsRESTClient(t, srv)
+
+	_, _, err := queryPods(context.Background(), rc, podQueryOpts{namespace: "ns1"})
+	require.NoError(t, err)
+	_, _, err = queryPods(context.Background(), rc, podQueryOpts{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/api/v1/namespaces/ns1/pods", "/api/v1/pods"}, paths)
+}
+
+func TestValidateResourceVersion(t *testing.T) {
+	require.NoError(t, validateResourceVersion("", true))
+	require.NoError(t, validateResourceVersion("12345", false))
+	require.NoError(t, validateResourceVersion("0", false))
+	require.ErrorContains(t, validateResourceVersion("abc", false), `"abc" is not a number`)
+	require.ErrorContains(t, validateResourceVersion("-1", false), "not a number")
+	require.ErrorContains(t, validateResourceVersion("12345", true), "--use-cache")
+}
+
+// rawPodRows returns n table rows with serialized (not yet parsed) pods.
+func rawPodRows(t testing.TB, n int) []metav1.TableRow {
+	rows := make([]metav1.TableRow, 0, n)
+	for i := 0; i < n; i++ {
+		raw, err := json.Marshal(&corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod%d", i), Namespace: "default", UID: uuid.NewUUID()},
+			Spec: corev1.PodSpec{
+				NodeName:   fmt.Sprintf("node%d", i%100),
+				Containers: []corev1.Container{{Name: "app", Image: "example.com/app:v1"}},
+			},
+		})
+		require.NoError(t, err)
+		rows = append(rows, metav1.TableRow{Object: runtime.RawExtension{Raw: raw}})
+	}
+	return rows
+}
+
+func TestParsePods(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		tbl := metav1.Table{Rows: rawPodRows(t, 2000)}
+		require.NoError(t, parsePods(&tbl, workers))
+		for i, row := range tbl.Rows {
+			require.Equal(t, fmt.Sprintf("pod%d", i), row.Object.Object.(*corev1.Pod).Name, "workers=%d", workers)
+		}
+
+		tbl = metav1.Table{Rows: rawPodRows(t, 2000)}
+		tbl.Rows[1700].Object.Raw = []byte("{")
+		require.ErrorContains(t, parsePods(&tbl, workers), "failed to decode pod in row 1700", "workers=%d", workers)
+	}
+}
+
+// BenchmarkParsePods compares parsing a large table sequentially and in
+// parallel.
+func BenchmarkParsePods(b *testing.B) {
+	rows := rawPodRows(b, 20000)
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tbl := metav1.Table{Rows: slices.Clone(rows)}
+				if err := parsePods(&tbl, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxResourceVersion(t *testing.T) {
+	require.Equal(t, "10", maxResourceVersion("9", "10"))
+	require.Equal(t, "10", maxResourceVersion("10", "9"))
+	require.Equal(t, "9", maxResourceVersion("9", ""))
+	require.Equal(t, "b", maxResourceVersion("a", "b"))
+}
+
+// BenchmarkFindPods compares querying pods by node (with and without
+// batching) against querying all pods, against a local fake apiserver. It
+// measures the client-side overhead of each strategy, not the apiserver's.
+func BenchmarkFindPods(b *testing.B) {
+	const totalNodes, podsPerNode = 200, 20
+	nodes := testNodeNames(totalNodes)
+	srv, _ := fakePodsServer(b, nodes, podsPerNode, nil)
+	rc := fakePodsRESTClient(b, srv)
+
+	for _, matched := range []int{10, 50, 200} {
+		matchedNodes := nodes[:matched]
+		b.Run(fmt.Sprintf("matched=%d/all-pods", matched), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := findPodsByQueryingAllPods(context.Background(), restPodLister{rc}, sets.New(matchedNodes...), podQueryOpts{})
+				require.NoError(b, err)
+			}
+		})
+		for _, batchSize := range []int{0, 10} {
+			b.Run(fmt.Sprintf("matched=%d/by-node/batch=%d", matched, batchSize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _, err := findPodsByQueryingNodesInParallel(context.Background(), restPodLister{rc}, matchedNodes, 20, batchSize, podQueryOpts{}, false)
+					require.NoError(b, err)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryPodsPodListFallback(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a server that ignores the Table content type
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(&corev1.PodList{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PodList"},
+			ListMeta: metav1.ListMeta{ResourceVersion: "42"},
+			Items: []corev1.Pod{{
+				ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns1", CreationTimestamp: created},
+				Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "c1"}, {Name: "c2"}}},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "c1", Ready: true, RestartCount: 2},
+					{Name: "c2", RestartCount: 1},
+				}},
+			}},
+		}))
+	}))
+	defer srv.Close()
+
+	out, stats, err := queryPods(context.Background(), fakePodsRESTClient(t, srv), podQueryOpts{})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.podsRetrieved)
+	require.Equal(t, "42", out.ResourceVersion)
+	require.Len(t, out.Rows, 1)
+	require.Equal(t, []interface{}{"a", "1/2", "Running", "3", "120m"}, out.Rows[0].Cells)
+	require.Equal(t, "node1", out.Rows[0].Object.Object.(*corev1.Pod).Spec.NodeName)
+
+	enhanced := enhanceTable(out, tableOpts{})
+	require.Equal(t, []interface{}{"node1", "ns1", "a", "1/2", "Running", "3", "120m"}, enhanced.Rows[0].Cells)
+}
+
+func TestQueryPodsMetadataOnly(t *testing.T) {
+	var includeObject string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeObject = r.URL.Query().Get("includeObject")
+		tbl := metav1.Table{
+			TypeMeta:          metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"},
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}, {Name: "Node", Type: "string"}},
+		}
+		for _, node := range []string{"node1", ""} {
+			pod := &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-" + node, Namespace: "default",
+					OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds"}}},
+				Spec: corev1.PodSpec{NodeName: node, Containers: []corev1.Container{{Image: "nginx"}}},
+			}
+			var obj interface{} = pod
+			if includeObject == string(metav1.IncludeMetadata) {
+				obj = &metav1.PartialObjectMetadata{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"},
+					ObjectMeta: pod.ObjectMeta,
+				}
+			}
+			raw, err := json.Marshal(obj)
+			require.NoError(t, err)
+			nodeCell := node
+			if node == "" {
+				nodeCell = "<none>"
+			}
+			tbl.Rows = append(tbl.Rows, metav1.TableRow{Cells: []interface{}{pod.Name, nodeCell}, Object: runtime.RawExtension{Raw: raw}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(tbl))
+	}))
+	t.Cleanup(srv.Close)
+	rc := fakePodsRESTClient(t, srv)
+
+	resp, _, err := queryPods(context.Background(), rc, podQueryOpts{})
+	require.NoError(t, err)
+	require.Equal(t, "Object", includeObject)
+	require.Len(t, resp.Rows[0].Object.Object.(*corev1.Pod).Spec.Containers, 1)
+
+	resp, _, err = queryPods(context.Background(), rc, podQueryOpts{metadataOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, "Metadata", includeObject)
+	require.Len(t, resp.Rows, 2)
+	pod := resp.Rows[0].Object.Object.(*corev1.Pod)
+	require.Equal(t, "pod-node1", pod.Name)
+	require.Equal(t, "node1", pod.Spec.NodeName)
+	require.Empty(t, pod.Spec.Containers)
+	require.Len(t, filterDaemonSetPods(resp).Rows, 0, "owner references are part of the metadata")
+	require.Equal(t, "", resp.Rows[1].Object.Object.(*corev1.Pod).Spec.NodeName, "unscheduled pod")
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// pprofAddrEnv is the environment variable to start the pprof server on the
+// given address without specifying the --pprof-addr flag.
+const pprofAddrEnv = "PODS_ON_PPROF"
+
+// startPprof starts the pprof server on the given address (or the address in
+// the PODS_ON_PPROF environment variable if addr is empty), if any. The
+// returned function should be called at the end of the program, and it blocks
+// forever to keep the program alive for inspection if wait is true.
+func startPprof(addr string, wait bool) func() {
+	if addr == "" {
+		addr = os.Getenv(pprofAddrEnv)
+	}
+	if addr == "" {
+		return func() {}
+	}
+
+	klog.Infof("starting pprof server at %s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			klog.Warning("failed to start pprof server: ", err)
+		}
+	}()
+
+	return func() {
+		if wait {
+			klog.Info("keeping program alive for pprof inspection")
+			select {}
+		}
+	}
+}
@@ -15,45 +15,655 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/cli-runtime/pkg/printers"
-	"k8s.io/klog/v2"
 	kubectlget "k8s.io/kubectl/pkg/cmd/get"
 	"k8s.io/kubectl/pkg/scheme"
 	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
 )
 
-func print(resp metav1.Table, printFlags *kubectlget.PrintFlags) error {
-	resourcePrinter, err := printFlags.ToPrinter()
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiInvert = "\x1b[7m"
+)
+
+type printOpts struct {
+	color     bool // colorize the STATUS column in table output
+	totals    bool // print a footer with pod and node counts after the table
+	listNodes bool // print only the names of the nodes hosting the pods
+	invert    bool // with listNodes, print the target nodes hosting none of the pods instead
+	compact   bool // print the pods on each node (or groupBy group) on a single line
+	groupBy   string
+
+	// fullOutput keeps the noisy metadata fields in non-table formats
+	fullOutput bool
+
+	// summary prints the number of pods per countBy group (e.g. node) after
+	// the pods (or instead of them in json/yaml formats), summaryOnly prints
+	// only the summary
+	summary, summaryOnly bool
+	countBy              string
+
+	// topNodes prints only the number of pods on the topNodes nodes with the
+	// most pods (if positive)
+	topNodes int
+
+	// nodeCapacity prints the allocatable CPU/memory of the nodes in
+	// tableOpts.nodes and the requests of the pods on them instead of the pods
+	nodeCapacity bool
+
+	// columns are the names of the only columns to print in table output (all
+	// columns if empty)
+	columns []string
+
+	// noEnhance prints the table of the server as is, without the columns
+	// added by enhanceTable
+	noEnhance bool
+
+	// groupByNode prints the pods as an object keyed by node name in json/yaml
+	// formats instead of a PodList
+	groupByNode bool
+
+	// deleteCommandPrefix is the kubectl command in -o delete-commands output
+	// (e.g. "kubectl --context=prod"), "kubectl" if empty, and
+	// deleteCommandFlags are the shell-quoted flags added after it to select
+	// the queried cluster (see kubectlFlags)
+	deleteCommandPrefix string
+	deleteCommandFlags  []string
+
+	// targetNodes is the nodes the pods were queried on (keyed by nodeKey),
+	// whose complement invert lists
+	targetNodes sets.Set[string]
+
+	tableOpts
+}
+
+// metadataOnly returns whether printing the pods (in table output) only needs
+// their metadata and node name. Only the options listed here are known to,
+// setting any other option needs the full pods.
+func (o printOpts) metadataOnly() bool {
+	supported := printOpts{
+		color:               o.color, // the status is a cell of the server's table
+		totals:              o.totals,
+		listNodes:           o.listNodes,
+		invert:              o.invert,
+		compact:             o.compact,
+		groupBy:             o.groupBy,
+		fullOutput:          o.fullOutput,
+		summary:             o.summary,
+		summaryOnly:         o.summaryOnly,
+		topNodes:            o.topNodes,
+		columns:             o.columns,
+		noEnhance:           o.noEnhance,
+		deleteCommandPrefix: o.deleteCommandPrefix,
+		deleteCommandFlags:  o.deleteCommandFlags,
+		targetNodes:         o.targetNodes,
+		tableOpts:           o.tableOpts,
+	}
+	if o.countBy != "phase" {
+		supported.countBy = o.countBy
+	}
+	return o.tableOpts.metadataOnly() && reflect.DeepEqual(o, supported)
+}
+
+func print(w io.Writer, resp metav1.Table, printFlags *kubectlget.PrintFlags, opts printOpts) error {
+	if opts.listNodes && opts.invert {
+		for _, node := range nodesWithoutPods(resp, opts.targetNodes, opts.podContexts) {
+			fmt.Fprintln(w, node)
+		}
+		return nil
+	}
+	if opts.listNodes {
+		for _, node := range sets.List(podNodeNames(resp)) {
+			fmt.Fprintln(w, node)
+		}
+		return nil
+	}
+	if opts.compact {
+		return printCompact(w, resp, opts.groupBy)
+	}
+
+	outputFormat := ptr.Deref(printFlags.OutputFormat, "")
+	isTable := isTableFormat(printFlags)
+	if opts.nodeCapacity {
+		return printNodeCapacity(w, nodeCapacities(resp, opts.nodes, opts.podContexts))
+	}
+	if opts.topNodes > 0 {
+		counts, err := countPodsBy(resp, "node")
+		if err != nil {
+			return err
+		}
+		return printSummary(w, topNodeCounts(counts, opts.topNodes), "node", outputFormat)
+	}
+	if opts.summaryOnly || (opts.summary && !isTable) {
+		// appending the summary would corrupt the machine-readable output
+		counts, err := countPodsBy(resp, opts.countBy)
+		if err != nil {
+			return err
+		}
+		return printSummary(w, counts, opts.countBy, outputFormat)
+	}
+
+	// The status colorizer locates the STATUS column from the header line, so
+	// always print the headers and strip them afterward if requested (on a
+	// copy of the flags, which are reused across the prints of a run).
+	printFlags = copyPrintFlags(printFlags)
+	noHeaders := ptr.Deref(printFlags.NoHeaders, false)
+	if opts.color && isTable && noHeaders {
+		printFlags.NoHeaders = ptr.To(false)
+	}
+
+	if opts.fullOutput {
+		// otherwise kubectl's json/yaml printers omit the managed fields
+		printFlags.JSONYamlPrintFlags.ShowManagedFields = true
+	}
+
+	p, err := newPrinter(printFlags, opts)
 	if err != nil {
-		klog.Fatalf("failed to get printer: %v", err)
+		return err
 	}
 	var obj runtime.Object
+	out := w
 
-	switch ptr.Deref(printFlags.OutputFormat, "") {
+	switch outputFormat {
 	case "", "wide":
 		// do nothing since the default format is table.
-		obj = ptr.To(enhanceTable(resp))
+		tblOpts := opts.tableOpts
+		if opts.color {
+			// highlight the rows of the recently restarted pods instead of
+			// marking their names
+			var highlight []bool
+			if tblOpts.recentRestarts != nil {
+				highlight = make([]bool, len(resp.Rows))
+				for i, row := range resp.Rows {
+					highlight[i] = tblOpts.recentRestarts.Has(row.Object.Object.(*corev1.Pod).UID)
+				}
+				tblOpts.recentRestarts = nil
+			}
+			out = &statusColorWriter{w: w, noHeaders: noHeaders, highlightRows: highlight}
+		}
+		tbl := resp
+		if !opts.noEnhance {
+			tbl = enhanceTable(resp, tblOpts)
+		}
+		if len(opts.columns) > 0 {
+			if tbl, err = selectColumns(tbl, opts.columns); err != nil {
+				return err
+			}
+		}
+		obj = &tbl
 	case "name":
-		klog.Fatal("output format 'name' is not supported in this plugin since the format doesn't contain namespace references")
+		return errors.New("output format 'name' is not supported in this plugin since the format doesn't contain namespace references")
 	default:
 		// other formats (json, yaml, etc), convert to PodList
-		obj = toPodList(resp)
+		list := toPodList(resp, podListOpts{
+			showManagedFields: printFlags.JSONYamlPrintFlags.ShowManagedFields,
+			fullOutput:        opts.fullOutput,
+		})
+		if opts.groupByNode && isStructuredFormat(outputFormat) {
+			return printPodsByNode(w, list, outputFormat)
+		}
+		obj = list
+	}
+	if err := p.PrintObj(obj, out); err != nil {
+		return err
+	}
+	if f, ok := out.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
 	}
-	p := printers.NewTypeSetter(scheme.Scheme).ToPrinter(resourcePrinter)
+	if opts.totals && isTable {
+		pods, nodes := countTotals(resp)
+		fmt.Fprintf(w, "Total: %d pods across %d nodes\n", pods, nodes)
+	}
+	if opts.summary && isTable {
+		counts, err := countPodsBy(resp, opts.countBy)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		return printSummary(w, counts, opts.countBy, outputFormat)
+	}
+	return nil
+}
+
+// copyPrintFlags returns a copy of the print flags that can be changed for a
+// single print, including the flags of the printers that ToPrinter sets.
+func copyPrintFlags(f *kubectlget.PrintFlags) *kubectlget.PrintFlags {
+	out := *f
+	jsonYaml, humanReadable, customColumns := *f.JSONYamlPrintFlags, *f.HumanReadableFlags, *f.CustomColumnsFlags
+	out.JSONYamlPrintFlags, out.HumanReadableFlags, out.CustomColumnsFlags = &jsonYaml, &humanReadable, &customColumns
+	return &out
+}
 
-	return p.PrintObj(obj, os.Stdout)
+// noisyAnnotations are well-known annotations that bloat the json/yaml output
+// and are omitted unless full output is requested.
+var noisyAnnotations = []string{
+	corev1.LastAppliedConfigAnnotation,
 }
 
-func toPodList(resp metav1.Table) *corev1.PodList {
+// podListOpts controls which noisy metadata fields are kept by toPodList.
+type podListOpts struct {
+	showManagedFields bool // keep metadata.managedFields
+	fullOutput        bool // keep all fields (implies showManagedFields)
+}
+
+// newPrinter returns the printer for the output format, which sets the
+// apiVersion/kind of the printed objects.
+func newPrinter(printFlags *kubectlget.PrintFlags, opts printOpts) (printers.ResourcePrinter, error) {
+	outputFormat := ptr.Deref(printFlags.OutputFormat, "")
+	if spec, ok := strings.CutPrefix(outputFormat, "custom-columns="); ok {
+		printFlags = copyPrintFlags(printFlags)
+		printFlags.OutputFormat = ptr.To("custom-columns=" + expandCustomColumnAliases(spec))
+	}
+
+	var resourcePrinter printers.ResourcePrinter
+	switch outputFormat {
+	case "jsonl", "ndjson":
+		resourcePrinter = &jsonLinesPrinter{}
+	case "delete-commands":
+		resourcePrinter = &deleteCommandsPrinter{prefix: opts.deleteCommandPrefix, flags: opts.deleteCommandFlags, podContexts: opts.podContexts}
+	default:
+		p, err := printFlags.ToPrinter()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get printer: %w", err)
+		}
+		resourcePrinter = p
+	}
+	return printers.NewTypeSetter(scheme.Scheme).ToPrinter(resourcePrinter), nil
+}
+
+// customColumnAliases are the column names that can be used without a field
+// spec in -o custom-columns, matching the columns of the default table output.
+var customColumnAliases = map[string]string{
+	"NODE":      ".spec.nodeName",
+	"NAMESPACE": ".metadata.namespace",
+	"NAME":      ".metadata.name",
+}
+
+// expandCustomColumnAliases expands the column aliases (e.g. NODE) without a
+// field spec in the custom-columns spec into NODE:.spec.nodeName.
+func expandCustomColumnAliases(spec string) string {
+	columns := strings.Split(spec, ",")
+	for i, col := range columns {
+		if strings.Contains(col, ":") {
+			continue
+		}
+		if path, ok := customColumnAliases[strings.ToUpper(col)]; ok {
+			columns[i] = strings.ToUpper(col) + ":" + path
+		}
+	}
+	return strings.Join(columns, ",")
+}
+
+// isTableFormat returns whether the output format is a (human-readable) table.
+func isTableFormat(printFlags *kubectlget.PrintFlags) bool {
+	outputFormat := ptr.Deref(printFlags.OutputFormat, "")
+	return outputFormat == "" || outputFormat == "wide"
+}
+
+// toPodList converts the table into a PodList with each pod typed as v1/Pod.
+// Unless requested otherwise, metadata.managedFields (like kubectl get does)
+// and noisy annotations are cleared from the pods as they bloat the output.
+func toPodList(resp metav1.Table, opts podListOpts) *corev1.PodList {
 	var list corev1.PodList
 	for _, row := range resp.Rows {
-		list.Items = append(list.Items, *row.Object.Object.(*corev1.Pod))
+		pod := *row.Object.Object.(*corev1.Pod)
+		pod.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+		if !opts.fullOutput {
+			if !opts.showManagedFields {
+				pod.ManagedFields = nil
+			}
+			pod.Annotations = withoutNoisyAnnotations(pod.Annotations)
+		}
+		list.Items = append(list.Items, pod)
 	}
 	list.ListMeta = resp.ListMeta
 	return &list
 }
+
+// withoutNoisyAnnotations returns a copy of the annotations without the
+// noisyAnnotations.
+func withoutNoisyAnnotations(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 {
+		return annotations
+	}
+	out := maps.Clone(annotations)
+	for _, key := range noisyAnnotations {
+		delete(out, key)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// jsonLinesPrinter prints each pod in a PodList as a compact JSON object on
+// its own line.
+type jsonLinesPrinter struct{}
+
+func (p *jsonLinesPrinter) PrintObj(obj runtime.Object, w io.Writer) error {
+	list, ok := obj.(*corev1.PodList)
+	if !ok {
+		return fmt.Errorf("jsonl printer: unexpected object type %T (expected *corev1.PodList)", obj)
+	}
+	encoder := kjson.NewSerializerWithOptions(kjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme, kjson.SerializerOptions{})
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if err := encoder.Encode(pod, w); err != nil {
+			return fmt.Errorf("failed to encode pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteCommandsPrinter prints a kubectl command to delete each pod in a
+// PodList on its own line, to be reviewed before running them.
+type deleteCommandsPrinter struct {
+	prefix string   // the kubectl command, "kubectl" if empty
+	flags  []string // the shell-quoted flags added after the prefix
+
+	// podContexts is the kubeconfig context of each pod (by pod UID), passed
+	// with --context if set
+	podContexts map[types.UID]string
+}
+
+func (p *deleteCommandsPrinter) PrintObj(obj runtime.Object, w io.Writer) error {
+	list, ok := obj.(*corev1.PodList)
+	if !ok {
+		return fmt.Errorf("delete-commands printer: unexpected object type %T (expected *corev1.PodList)", obj)
+	}
+	prefix := p.prefix
+	if prefix == "" {
+		prefix = "kubectl"
+	}
+	if len(p.flags) > 0 {
+		prefix += " " + strings.Join(p.flags, " ")
+	}
+	for _, pod := range list.Items {
+		cmd := prefix
+		if ctx, ok := p.podContexts[pod.UID]; ok {
+			cmd += " --context=" + shellQuote(ctx)
+		}
+		if _, err := fmt.Fprintf(w, "%s delete pod %s -n %s\n", cmd, shellQuote(pod.Name), shellQuote(pod.Namespace)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s with single quotes for POSIX shells, unless it only has
+// characters that don't need quoting.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// countTotals returns the number of pods in the table and the number of
+// distinct nodes they're scheduled on.
+func countTotals(resp metav1.Table) (pods, nodes int) {
+	return len(resp.Rows), podNodeNames(resp).Len()
+}
+
+// podNodeNames returns the distinct names of the nodes the pods in the table
+// are scheduled on.
+func podNodeNames(resp metav1.Table) sets.Set[string] {
+	nodeNames := sets.New[string]()
+	for _, row := range resp.Rows {
+		if nodeName := row.Object.Object.(*corev1.Pod).Spec.NodeName; nodeName != "" {
+			nodeNames.Insert(nodeName) // unscheduled pods have no node
+		}
+	}
+	return nodeNames
+}
+
+// nodesWithoutPods returns the sorted target nodes (keyed by nodeKey) that
+// none of the pods in the table are on.
+func nodesWithoutPods(resp metav1.Table, targetNodes sets.Set[string], podContexts map[types.UID]string) []string {
+	withPods := sets.New[string]()
+	for _, row := range resp.Rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		withPods.Insert(nodeKey(podContexts[pod.UID], pod.Spec.NodeName))
+	}
+	return sets.List(targetNodes.Difference(withPods))
+}
+
+// printCompact prints each node hosting the pods on a line (sorted by node
+// name) followed by the comma-separated namespace/name of the pods on it in
+// the order of the rows, e.g. "node1: ns1/pod-a, ns2/pod-b". If groupBy is
+// namespace, each namespace is printed on a line instead with the name and
+// node of its pods, e.g. "ns1: pod-a (node1), pod-b (node2)".
+func printCompact(w io.Writer, resp metav1.Table, groupBy string) error {
+	podsByGroup := make(map[string][]string)
+	for _, row := range resp.Rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" {
+			nodeName = "<none>" // unscheduled
+		}
+		if groupBy == "namespace" {
+			podsByGroup[pod.Namespace] = append(podsByGroup[pod.Namespace], fmt.Sprintf("%s (%s)", pod.Name, nodeName))
+		} else {
+			podsByGroup[nodeName] = append(podsByGroup[nodeName], pod.Namespace+"/"+pod.Name)
+		}
+	}
+	for _, group := range sets.List(sets.KeySet(podsByGroup)) {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", group, strings.Join(podsByGroup[group], ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printPodsByNode prints the pods in the list to w as a json/yaml object
+// keyed by the name of the node they're on (<none> for unscheduled pods), e.g.
+// {"node1": [{"kind": "Pod", ...}]}. This is not a Kubernetes API type.
+func printPodsByNode(w io.Writer, list *corev1.PodList, outputFormat string) error {
+	podsByNode := make(map[string][]corev1.Pod)
+	for _, pod := range list.Items {
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" {
+			nodeName = "<none>" // unscheduled
+		}
+		podsByNode[nodeName] = append(podsByNode[nodeName], pod)
+	}
+	var b []byte
+	var err error
+	if outputFormat == "json" {
+		b, err = json.MarshalIndent(podsByNode, "", "    ")
+		b = append(b, '\n')
+	} else {
+		b, err = yaml.Marshal(podsByNode)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// printPodList prints the pods in the table to w as a v1/PodList in the json
+// or yaml format.
+func printPodList(w io.Writer, resp metav1.Table, format string, opts podListOpts) error {
+	var p printers.ResourcePrinter = &printers.YAMLPrinter{}
+	if format == "json" {
+		p = &printers.JSONPrinter{}
+	}
+	return printers.NewTypeSetter(scheme.Scheme).ToPrinter(p).PrintObj(toPodList(resp, opts), w)
+}
+
+// writePodListFiles writes the pods in the table as a v1/PodList in the json
+// and yaml formats to the files at the given paths (if not empty), in
+// addition to the primary output (--also-output-json/yaml).
+func writePodListFiles(resp metav1.Table, jsonPath, yamlPath string, opts podListOpts) error {
+	for _, f := range []struct{ path, format string }{{jsonPath, "json"}, {yamlPath, "yaml"}} {
+		if f.path == "" {
+			continue
+		}
+		f := f
+		if err := writeOutput(f.path, func(w io.Writer) error {
+			return printPodList(w, resp, f.format, opts)
+		}); err != nil {
+			return fmt.Errorf("failed to write %s output to %s: %w", f.format, f.path, err)
+		}
+	}
+	return nil
+}
+
+// writeOutput calls write with the file at path (created or truncated), or
+// with stdout if path is empty.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// shouldColorize decides whether the table output should be colorized based
+// on the --color flag value, the NO_COLOR convention and whether the output
+// is a terminal.
+func shouldColorize(mode string, isTerminal bool) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto, "":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			return false, nil
+		}
+		return isTerminal, nil
+	default:
+		return false, fmt.Errorf("invalid --color value %q (expected one of: %s, %s, %s)", mode, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// statusColorWriter buffers the table printed by kubectl's printer and
+// colorizes the STATUS column on Flush. Colorizing after the table is laid
+// out keeps the ANSI escape sequences from throwing off column alignment.
+type statusColorWriter struct {
+	w         io.Writer
+	noHeaders bool
+	buf       bytes.Buffer
+
+	// highlightRows is whether to highlight each row (after the header)
+	highlightRows []bool
+}
+
+func (s *statusColorWriter) Write(p []byte) (int, error) { return s.buf.Write(p) }
+
+func (s *statusColorWriter) Flush() error {
+	out := highlightLines(colorizeStatusColumn(s.buf.String()), s.highlightRows)
+	if s.noHeaders {
+		_, out, _ = strings.Cut(out, "\n")
+	}
+	_, err := io.WriteString(s.w, out)
+	return err
+}
+
+var statusHeaderRe = regexp.MustCompile(`(^|\s)STATUS(\s|$)`)
+
+// colorizeStatusColumn finds the STATUS column from the header line of the
+// given table output and wraps the status value in each subsequent line with
+// the color corresponding to the status.
+func colorizeStatusColumn(table string) string {
+	lines := strings.Split(table, "\n")
+	if len(lines) == 0 {
+		return table
+	}
+	loc := statusHeaderRe.FindStringIndex(lines[0])
+	if loc == nil {
+		return table
+	}
+	col := strings.Index(lines[0][loc[0]:], "STATUS") + loc[0]
+
+	for i := 1; i < len(lines); i++ {
+		line := lines[i]
+		if len(line) <= col || (col > 0 && line[col-1] != ' ') {
+			continue
+		}
+		end := strings.IndexByte(line[col:], ' ')
+		if end < 0 {
+			end = len(line) - col
+		}
+		status := line[col : col+end]
+		color := statusColor(status)
+		if color == "" {
+			continue
+		}
+		lines[i] = line[:col] + color + status + ansiReset + line[col+end:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// highlightLines inverts the colors of the lines after the header line of the
+// table output for which highlight is true.
+func highlightLines(table string, highlight []bool) string {
+	lines := strings.Split(table, "\n")
+	for i, h := range highlight {
+		if !h || i+1 >= len(lines) {
+			continue
+		}
+		// re-apply the highlight after the resets of the status colors
+		line := strings.ReplaceAll(lines[i+1], ansiReset, ansiReset+ansiInvert)
+		lines[i+1] = ansiInvert + line + ansiReset
+	}
+	return strings.Join(lines, "\n")
+}
+
+// statusColor returns the ANSI color sequence for the given pod status as
+// displayed by kubectl, or an empty string if the status is not colorized.
+func statusColor(status string) string {
+	switch status {
+	case "Running", "Completed", "Succeeded":
+		return ansiGreen
+	case "Pending", "ContainerCreating", "PodInitializing":
+		return ansiYellow
+	case "CrashLoopBackOff", "Error", "Failed", "ImagePullBackOff", "ErrImagePull", "OOMKilled":
+		return ansiRed
+	}
+	if reason, ok := strings.CutPrefix(status, "Init:"); ok {
+		if statusColor(reason) == ansiRed {
+			return ansiRed
+		}
+		return ansiYellow
+	}
+	return ""
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestColorizeStatusColumn(t *testing.T) {
+	in := "NODE    NAMESPACE   NAME   READY   STATUS             RESTARTS   AGE\n" +
+		"node1   default     a      1/1     Running            0          1d\n" +
+		"node1   default     b      0/1     CrashLoopBackOff   5          1d\n" +
+		"node2   default     c      0/1     Pending            0          1d\n" +
+		"node2   default     d      0/1     Terminating        0          1d\n"
+
+	out := colorizeStatusColumn(in)
+	require.Equal(t, "NODE    NAMESPACE   NAME   READY   STATUS             RESTARTS   AGE\n"+
+		"node1   default     a      1/1     "+ansiGreen+"Running"+ansiReset+"            0          1d\n"+
+		"node1   default     b      0/1     "+ansiRed+"CrashLoopBackOff"+ansiReset+"   5          1d\n"+
+		"node2   default     c      0/1     "+ansiYellow+"Pending"+ansiReset+"            0          1d\n"+
+		"node2   default     d      0/1     Terminating        0          1d\n", out)
+
+	t.Run("no status column", func(t *testing.T) {
+		require.Equal(t, "FOO\nRunning\n", colorizeStatusColumn("FOO\nRunning\n"))
+	})
+}
+
+func TestHighlightLines(t *testing.T) {
+	in := "NAME   STATUS\n" +
+		"a      " + ansiGreen + "Running" + ansiReset + "\n" +
+		"b      Terminating\n"
+	require.Equal(t, "NAME   STATUS\n"+
+		ansiInvert+"a      "+ansiGreen+"Running"+ansiReset+ansiInvert+ansiReset+"\n"+
+		"b      Terminating\n", highlightLines(in, []bool{true, false}))
+	require.Equal(t, in, highlightLines(in, nil))
+}
+
+func TestStatusColor(t *testing.T) {
+	require.Equal(t, ansiGreen, statusColor("Completed"))
+	require.Equal(t, ansiYellow, statusColor("Init:0/1"))
+	require.Equal(t, ansiRed, statusColor("Init:CrashLoopBackOff"))
+	require.Equal(t, "", statusColor("Unknown"))
+}
+
+func TestCountTotals(t *testing.T) {
+	row := func(node string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{Spec: corev1.PodSpec{NodeName: node}}}}
+	}
+	pods, nodes := countTotals(metav1.Table{Rows: []metav1.TableRow{
+		row("node1"), row("node2"), row("node1"),
+	}})
+	require.Equal(t, 3, pods)
+	require.Equal(t, 2, nodes)
+
+	// unscheduled pods (--include-unscheduled) are not on a node
+	pods, nodes = countTotals(metav1.Table{Rows: []metav1.TableRow{
+		row("node1"), row(""), row(""),
+	}})
+	require.Equal(t, 3, pods)
+	require.Equal(t, 1, nodes)
+
+	pods, nodes = countTotals(metav1.Table{})
+	require.Zero(t, pods)
+	require.Zero(t, nodes)
+}
+
+func TestPrintListNodesInvert(t *testing.T) {
+	row := func(uid types.UID, node string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: uid},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}}}
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{row("a", "node2"), row("b", "node2"), row("c", "")}}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{listNodes: true, invert: true, targetNodes: sets.New("node3", "node1", "node2")}))
+	require.Equal(t, "node1\nnode3\n", b.String())
+
+	// the nodes of each context are told apart
+	b.Reset()
+	require.NoError(t, print(&b, resp, printFlags, printOpts{
+		listNodes:   true,
+		invert:      true,
+		targetNodes: sets.New(nodeKey("ctx1", "node2"), nodeKey("ctx2", "node2")),
+		tableOpts:   tableOpts{podContexts: map[types.UID]string{"a": "ctx1", "b": "ctx1", "c": "ctx2"}},
+	}))
+	require.Equal(t, "ctx2/node2\n", b.String())
+}
+
+func TestPrintSummaryJSONLines(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "b"},
+			Spec:       corev1.PodSpec{NodeName: "node2"},
+		}}},
+	}}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	printFlags.OutputFormat = ptr.To("jsonl")
+
+	// the counts replace the pods instead of a text table being appended
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{summary: true, countBy: "node"}))
+	require.Equal(t, "{\"node\":\"node1\",\"pods\":1}\n{\"node\":\"node2\",\"pods\":1}\n", b.String())
+
+	b.Reset()
+	require.NoError(t, print(&b, resp, printFlags, printOpts{summary: true, countBy: "namespace"}))
+	require.Equal(t, "{\"namespace\":\"ns1\",\"pods\":2}\n", b.String())
+}
+
+func TestPrintKeepsFlags(t *testing.T) {
+	resp := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}, {Name: "Status", Type: "string"}},
+		Rows: []metav1.TableRow{{Cells: []interface{}{"a", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}}},
+	}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	printFlags.NoHeaders = ptr.To(true)
+
+	// the same flags print the output and the --also-output-* files
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{color: true, fullOutput: true}))
+	require.True(t, *printFlags.NoHeaders)
+	require.False(t, printFlags.JSONYamlPrintFlags.ShowManagedFields)
+
+	printFlags.OutputFormat = ptr.To("custom-columns=NODE,NAME")
+	_, err := newPrinter(printFlags, printOpts{})
+	require.NoError(t, err)
+	require.Equal(t, "custom-columns=NODE,NAME", *printFlags.OutputFormat)
+}
+
+func TestToPodList(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:          "a",
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+			Annotations: map[string]string{
+				"foo":                              "bar",
+				corev1.LastAppliedConfigAnnotation: "{}",
+			},
+		}}}},
+	}}
+
+	list := toPodList(resp, podListOpts{})
+	require.Len(t, list.Items, 1)
+	require.Nil(t, list.Items[0].ManagedFields)
+	require.Equal(t, map[string]string{"foo": "bar"}, list.Items[0].Annotations)
+	require.Equal(t, "v1", list.Items[0].APIVersion)
+	require.Equal(t, "Pod", list.Items[0].Kind)
+	require.NotNil(t, resp.Rows[0].Object.Object.(*corev1.Pod).ManagedFields, "input pod should not be modified")
+	require.Len(t, resp.Rows[0].Object.Object.(*corev1.Pod).Annotations, 2, "input pod should not be modified")
+
+	list = toPodList(resp, podListOpts{showManagedFields: true})
+	require.Len(t, list.Items[0].ManagedFields, 1)
+	require.NotContains(t, list.Items[0].Annotations, corev1.LastAppliedConfigAnnotation)
+
+	list = toPodList(resp, podListOpts{fullOutput: true})
+	require.Len(t, list.Items[0].ManagedFields, 1)
+	require.Contains(t, list.Items[0].Annotations, corev1.LastAppliedConfigAnnotation)
+}
+
+func TestJSONLinesPrinter(t *testing.T) {
+	list := toPodList(metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"}}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b"}}}},
+	}}, podListOpts{})
+	var b bytes.Buffer
+	require.NoError(t, (&jsonLinesPrinter{}).PrintObj(list, &b))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{"apiVersion":"v1","kind":"Pod","metadata":{"namespace":"ns1","name":"a","creationTimestamp":null},"spec":{"containers":null},"status":{}}`, lines[0])
+	require.Contains(t, lines[1], `"name":"b"`)
+}
+
+func TestDeleteCommandsPrinter(t *testing.T) {
+	list := toPodList(metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a", UID: "u1"}}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b", UID: "u2"}}}},
+	}}, podListOpts{})
+	var b bytes.Buffer
+	require.NoError(t, (&deleteCommandsPrinter{}).PrintObj(list, &b))
+	require.Equal(t, "kubectl delete pod a -n ns1\nkubectl delete pod b -n ns2\n", b.String())
+
+	b.Reset()
+	require.NoError(t, (&deleteCommandsPrinter{prefix: "kubectl --as=admin", podContexts: map[types.UID]string{"u1": "prod", "u2": "dev's cluster"}}).PrintObj(list, &b))
+	require.Equal(t, "kubectl --as=admin --context=prod delete pod a -n ns1\nkubectl --as=admin --context='dev'\\''s cluster' delete pod b -n ns2\n", b.String())
+
+	b.Reset()
+	require.NoError(t, (&deleteCommandsPrinter{flags: []string{"--context=staging"}}).PrintObj(list, &b))
+	require.Equal(t, "kubectl --context=staging delete pod a -n ns1\nkubectl --context=staging delete pod b -n ns2\n", b.String())
+
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	printFlags.OutputFormat = ptr.To("delete-commands")
+	b.Reset()
+	require.NoError(t, print(&b, metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"}}}},
+	}}, printFlags, printOpts{deleteCommandPrefix: "kubectl --context=prod"}))
+	require.Equal(t, "kubectl --context=prod delete pod a -n ns1\n", b.String())
+}
+
+func TestTemplateOutputFormats(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b"},
+			Spec:       corev1.PodSpec{NodeName: "node2"},
+		}}},
+	}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"jsonpath={.items[*].metadata.name}", "a b"},
+		{"jsonpath={.kind}/{.apiVersion} {.items[0].kind}", "PodList/v1 Pod"},
+		{`go-template={{range .items}}{{.spec.nodeName}}:{{.metadata.name}} {{end}}`, "node1:a node2:b "},
+		{"custom-columns=NODE:.spec.nodeName,NAME:.metadata.name", "NODE    NAME\nnode1   a\nnode2   b\n"},
+		{"custom-columns=NODE,namespace,NAME", "NODE    NAMESPACE   NAME\nnode1   ns1         a\nnode2   ns2         b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+			printFlags.OutputFormat = ptr.To(tt.format)
+
+			p, err := newPrinter(printFlags, printOpts{})
+			require.NoError(t, err)
+			var b bytes.Buffer
+			require.NoError(t, p.PrintObj(toPodList(resp, podListOpts{}), &b))
+			require.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+func TestTemplateFileOutputFormat(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b"},
+			Spec:       corev1.PodSpec{NodeName: "node2"},
+		}}},
+	}}
+	tmpl := filepath.Join(t.TempDir(), "pods.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte("{{range .items}}{{.spec.nodeName}}\t{{.metadata.namespace}}/{{.metadata.name}}\n{{end}}"), 0o644))
+
+	for _, format := range []string{"go-template-file=" + tmpl, "go-template-file"} {
+		t.Run(format, func(t *testing.T) {
+			printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+			printFlags.OutputFormat = ptr.To(format)
+			if format == "go-template-file" {
+				*printFlags.TemplateFlags.TemplateArgument = tmpl // as set by --template
+			}
+
+			p, err := newPrinter(printFlags, printOpts{})
+			require.NoError(t, err)
+			var b bytes.Buffer
+			require.NoError(t, p.PrintObj(toPodList(resp, podListOpts{}), &b))
+			require.Equal(t, "node1\tns1/a\nnode2\tns2/b\n", b.String())
+		})
+	}
+}
+
+func TestExpandCustomColumnAliases(t *testing.T) {
+	require.Equal(t, "NODE:.spec.nodeName,NAMESPACE:.metadata.namespace,IP:.status.podIP",
+		expandCustomColumnAliases("NODE,namespace,IP:.status.podIP"))
+	require.Equal(t, "NODE:.spec.hostname,FOO", expandCustomColumnAliases("NODE:.spec.hostname,FOO"))
+}
+
+func TestLabelColumns(t *testing.T) {
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	printFlags.HumanReadableFlags.ColumnLabels = ptr.To([]string{"app", "example.com/tier"})
+
+	resp := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "a", Labels: map[string]string{"app": "web", "example.com/tier": "frontend"}},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+			{Cells: []interface{}{"b"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "b"},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+		},
+	}
+	p, err := newPrinter(printFlags, printOpts{})
+	require.NoError(t, err)
+	var b bytes.Buffer
+	require.NoError(t, p.PrintObj(ptr.To(enhanceTable(resp, tableOpts{})), &b))
+	require.Equal(t, "NODE    NAMESPACE   NAME   APP   TIER\n"+
+		"node1   ns          a      web   frontend\n"+
+		"node1   ns          b            \n", b.String())
+}
+
+func TestWriteOutput(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}},
+	}}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	printFlags.OutputFormat = ptr.To("jsonpath={.items[*].metadata.name}")
+
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{}))
+	require.Equal(t, "a", b.String())
+
+	path := filepath.Join(t.TempDir(), "pods.txt")
+	require.NoError(t, os.WriteFile(path, []byte("previous contents"), 0o644))
+	require.NoError(t, writeOutput(path, func(w io.Writer) error {
+		return print(w, resp, printFlags, printOpts{listNodes: true})
+	}))
+	out, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "node1\n", string(out))
+
+	require.Error(t, writeOutput(filepath.Join(t.TempDir(), "missing", "pods.txt"), func(io.Writer) error { return nil }))
+}
+
+func TestPrintOptsMetadataOnly(t *testing.T) {
+	require.True(t, printOpts{countBy: "node", summary: true, tableOpts: tableOpts{showNodeStatus: true, showUID: true}}.metadataOnly())
+	require.False(t, printOpts{countBy: "phase"}.metadataOnly())
+	require.False(t, printOpts{nodeCapacity: true}.metadataOnly())
+	require.False(t, printOpts{tableOpts: tableOpts{showContainers: true}}.metadataOnly())
+	require.False(t, printOpts{tableOpts: tableOpts{showScheduling: true}}.metadataOnly())
+}
+
+func TestShouldColorize(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+	for _, tt := range []struct {
+		mode       string
+		isTerminal bool
+		want       bool
+	}{
+		{colorAuto, true, true},
+		{colorAuto, false, false},
+		{colorAlways, false, true},
+		{colorNever, true, false},
+	} {
+		got, err := shouldColorize(tt.mode, tt.isTerminal)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got, "mode=%s isTerminal=%v", tt.mode, tt.isTerminal)
+	}
+	_, err := shouldColorize("sometimes", true)
+	require.Error(t, err)
+}
+
+func TestPrintTable(t *testing.T) {
+	resp := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}, {Name: "Status", Type: "string"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+			{Cells: []interface{}{"b", "Pending"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b"},
+				Spec:       corev1.PodSpec{NodeName: "node2"},
+			}}},
+		},
+	}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{totals: true, summary: true, countBy: "node"}))
+	require.Equal(t, "NODE    NAMESPACE   NAME   STATUS\n"+
+		"node1   ns1         a      Running\n"+
+		"node2   ns2         b      Pending\n"+
+		"Total: 2 pods across 2 nodes\n"+
+		"\n"+
+		"NODE    PODS\n"+
+		"node1   1\n"+
+		"node2   1\n", b.String())
+}
+
+func TestPrintTruncate(t *testing.T) {
+	resp := func() metav1.Table {
+		return metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}},
+			Rows: []metav1.TableRow{
+				{Cells: []interface{}{"long-pod-name"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "long-namespace", Name: "long-pod-name"},
+					Spec:       corev1.PodSpec{NodeName: "long-node-name"},
+				}}},
+			},
+		}
+	}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp(), printFlags, printOpts{tableOpts: tableOpts{truncate: 6}}))
+	require.Equal(t, "NODE     NAMESPACE   NAME\nlong-…   long-…      long-…\n", b.String())
+
+	// never truncated in other formats
+	printFlags.OutputFormat = ptr.To("json")
+	b.Reset()
+	require.NoError(t, print(&b, resp(), printFlags, printOpts{tableOpts: tableOpts{truncate: 6}}))
+	require.Contains(t, b.String(), `"name": "long-pod-name"`)
+	require.Contains(t, b.String(), `"namespace": "long-namespace"`)
+	require.Contains(t, b.String(), `"nodeName": "long-node-name"`)
+}
+
+func TestPrintColumns(t *testing.T) {
+	resp := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}, {Name: "Ready", Type: "string"}, {Name: "Status", Type: "string"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a", "1/1", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+		},
+	}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{columns: []string{"name", "status", "node"}}))
+	require.Equal(t, "NODE    NAME   STATUS\nnode1   a      Running\n", b.String())
+}
+
+func TestPrintNoEnhance(t *testing.T) {
+	resp := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}, {Name: "Status", Type: "string"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a", UID: "u1"},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+		},
+	}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{noEnhance: true, tableOpts: tableOpts{showUID: true}}))
+	require.Equal(t, "NAME   STATUS\na      Running\n", b.String())
+}
+
+func TestPrintPodList(t *testing.T) {
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+		}}},
+		{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "b"},
+			Spec:       corev1.PodSpec{NodeName: "node2"},
+		}}},
+	}}
+
+	var b bytes.Buffer
+	require.NoError(t, printPodList(&b, resp, "json", podListOpts{}))
+	var list corev1.PodList
+	require.NoError(t, json.Unmarshal(b.Bytes(), &list))
+	require.Equal(t, "PodList", list.Kind)
+	require.Len(t, list.Items, 2)
+	require.Equal(t, "a", list.Items[0].Name)
+	require.Equal(t, "node2", list.Items[1].Spec.NodeName)
+	require.Empty(t, list.Items[0].ManagedFields)
+
+	b.Reset()
+	require.NoError(t, printPodList(&b, resp, "yaml", podListOpts{}))
+	require.Contains(t, b.String(), "kind: PodList\n")
+
+	dir := t.TempDir()
+	jsonPath, yamlPath := filepath.Join(dir, "pods.json"), filepath.Join(dir, "pods.yaml")
+	require.NoError(t, writePodListFiles(resp, jsonPath, yamlPath, podListOpts{}))
+	out, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &list))
+	require.Len(t, list.Items, 2)
+	out, err = os.ReadFile(yamlPath)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "nodeName: node1")
+}
+
+func TestPrintPodsByNode(t *testing.T) {
+	row := func(node, ns, name string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}}}
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		row("node1", "ns1", "a"),
+		row("node2", "ns1", "b"),
+		row("node1", "ns2", "c"),
+		row("", "ns1", "pending"),
+	}}
+
+	for _, format := range []string{"json", "yaml"} {
+		printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+		printFlags.OutputFormat = ptr.To(format)
+		var b bytes.Buffer
+		require.NoError(t, print(&b, resp, printFlags, printOpts{groupByNode: true}), format)
+
+		var podsByNode map[string][]corev1.Pod
+		require.NoError(t, yaml.Unmarshal(b.Bytes(), &podsByNode), format)
+		require.Len(t, podsByNode, 3, format)
+		require.Len(t, podsByNode["node1"], 2, format)
+		require.Equal(t, "a", podsByNode["node1"][0].Name, format)
+		require.Equal(t, "ns2", podsByNode["node1"][1].Namespace, format)
+		require.Equal(t, "Pod", podsByNode["node1"][0].Kind, format)
+		require.Equal(t, "b", podsByNode["node2"][0].Name, format)
+		require.Equal(t, "pending", podsByNode["<none>"][0].Name, format)
+	}
+}
+
+func TestPrintCompact(t *testing.T) {
+	row := func(node, ns, name string) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}}}
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		row("node2", "ns2", "pod-c"),
+		row("node1", "ns1", "pod-a"),
+		row("node1", "ns1", "pod-b"),
+		row("node2", "ns1", "pod-d"),
+	}}
+	printFlags := addPrintFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+
+	var b bytes.Buffer
+	require.NoError(t, print(&b, resp, printFlags, printOpts{compact: true}))
+	require.Equal(t, "node1: ns1/pod-a, ns1/pod-b\n"+
+		"node2: ns2/pod-c, ns1/pod-d\n", b.String())
+
+	b.Reset()
+	slices.SortFunc(resp.Rows, cmpPodRowByNamespace)
+	require.NoError(t, print(&b, resp, printFlags, printOpts{compact: true, groupBy: "namespace"}))
+	require.Equal(t, "ns1: pod-a (node1), pod-b (node1), pod-d (node2)\n"+
+		"ns2: pod-c (node2)\n", b.String())
+}
+
+func TestShellQuote(t *testing.T) {
+	require.Equal(t, "gke_project_us-central1_prod", shellQuote("gke_project_us-central1_prod"))
+	require.Equal(t, "arn:aws:eks:us-east-1:123:cluster/prod", shellQuote("arn:aws:eks:us-east-1:123:cluster/prod"))
+	require.Equal(t, "'my context'", shellQuote("my context"))
+	require.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	require.Equal(t, "'$(rm -rf ~)'", shellQuote("$(rm -rf ~)"))
+	require.Equal(t, "''", shellQuote(""))
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// retryBackoff is the backoff between the retries of API calls.
+var retryBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// withRetries calls fn, and retries it up to maxRetries times with
+// exponential backoff if it fails with a retryable error. It stops waiting
+// for the next attempt when ctx is done.
+func withRetries(ctx context.Context, maxRetries int, fn func() error) error {
+	backoff := retryBackoff
+	backoff.Steps = maxRetries + 1
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			klog.V(2).Infof("retrying API call (attempt %d/%d)", attempt, maxRetries)
+		}
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt >= maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// isRetryable returns whether the error is a transient error worth retrying.
+func isRetryable(err error) bool {
+	var netErr net.Error
+	return apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		errors.As(err, &netErr)
+}
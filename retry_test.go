@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestWithRetries(t *testing.T) {
+	defaultBackoff := retryBackoff
+	t.Cleanup(func() { retryBackoff = defaultBackoff })
+	retryBackoff.Duration = 0
+
+	failTimes := func(n int, err error) (func() error, *int) {
+		var calls int
+		return func() error {
+			calls++
+			if calls <= n {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+	throttled := apierrors.NewTooManyRequests("slow down", 1)
+
+	t.Run("fails twice then succeeds", func(t *testing.T) {
+		fn, calls := failTimes(2, throttled)
+		require.NoError(t, withRetries(context.Background(), 3, fn))
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("retries exhausted", func(t *testing.T) {
+		fn, calls := failTimes(2, throttled)
+		require.True(t, apierrors.IsTooManyRequests(withRetries(context.Background(), 1, fn)))
+		require.Equal(t, 2, *calls)
+	})
+	t.Run("no retries", func(t *testing.T) {
+		fn, calls := failTimes(1, apierrors.NewServerTimeout(schema.GroupResource{Resource: "nodes"}, "list", 1))
+		require.Error(t, withRetries(context.Background(), 0, fn))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("non-retryable error", func(t *testing.T) {
+		fn, calls := failTimes(2, errors.New("forbidden"))
+		require.EqualError(t, withRetries(context.Background(), 3, fn), "forbidden")
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("context canceled while waiting", func(t *testing.T) {
+		retryBackoff.Duration = time.Hour
+		t.Cleanup(func() { retryBackoff.Duration = 0 })
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		fn, calls := failTimes(2, throttled)
+		require.ErrorIs(t, withRetries(ctx, 3, fn), context.DeadlineExceeded)
+		require.Equal(t, 1, *calls)
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// serveOpts are the options of --serve applied to every query.
+type serveOpts struct {
+	namespace          string          // only cache the pods in the namespace (all namespaces if empty)
+	podLabels          labels.Selector // selects the pods
+	includeUnscheduled bool            // also respond with the pods not scheduled to a node
+	filters            podFilters
+}
+
+// serve answers pod queries over HTTP on addr from informer caches of all the
+// pods and nodes (--serve) until ctx is done, so repeated queries don't list
+// the pods from the API server.
+func serve(ctx context.Context, addr string, clientset kubernetes.Interface, opts serveOpts) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(opts.namespace), informers.WithTransform(func(obj interface{}) (interface{}, error) {
+		// managed fields are never served, and take a lot of memory
+		if m, err := meta.Accessor(obj); err == nil {
+			m.SetManagedFields(nil)
+		}
+		return obj, nil
+	}))
+	// the informers are registered before starting the factory
+	podInformer := factory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(cache.Indexers{podNodeNameIndex: podNodeName}); err != nil {
+		return fmt.Errorf("failed to index the pods by node: %w", err)
+	}
+	srv := &podsServer{
+		pods:               podInformer.GetIndexer(),
+		nodes:              factory.Core().V1().Nodes().Lister(),
+		podLabels:          opts.podLabels,
+		includeUnscheduled: opts.includeUnscheduled,
+		filters:            opts.filters,
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	start := time.Now()
+	klog.Info("waiting for the pod and node caches to sync")
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync the cache of %v", typ)
+		}
+	}
+	klog.V(1).Infof("caches synced, took %v", time.Since(start).Truncate(time.Millisecond))
+
+	httpServer := &http.Server{Addr: addr, Handler: srv.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		if err := httpServer.Shutdown(context.Background()); err != nil {
+			klog.Warningf("failed to shut down the server: %v", err)
+		}
+	}()
+	klog.Infof("serving pod queries at %s", addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// podNodeNameIndex is the index of the cached pods by spec.nodeName.
+const podNodeNameIndex = "spec.nodeName"
+
+func podNodeName(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// podsServer answers pod queries from the pod cache (indexed by node name)
+// and the node lister.
+type podsServer struct {
+	pods               cache.Indexer
+	nodes              corev1listers.NodeLister
+	podLabels          labels.Selector // selects the pods of every query
+	includeUnscheduled bool            // also respond with the unscheduled pods to every query
+	filters            podFilters      // applied to the pods of every query
+}
+
+func (s *podsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pods", s.handlePods)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// handlePods handles GET /pods?arg=<node name, internal IP or selector>
+// (repeated like the positional arguments, or all-nodes=true), and responds
+// with the pods on the matched nodes as a v1/PodList in JSON.
+func (s *podsServer) handlePods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	mode := posArgsAuto
+	if r.URL.Query().Get("all-nodes") == "true" {
+		mode = posArgsAllNodes
+	}
+	selectors, nodeNames, err := parsePosArgs(r.URL.Query()["arg"], mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := s.queryPods(r.Context(), selectors, nodeNames)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		klog.V(1).Infof("failed to write the response: %v", err)
+	}
+}
+
+// queryPods returns the pods on the nodes matching the selectors, names or
+// internal IPs (sorted by node, namespace and name) that pass the filters.
+func (s *podsServer) queryPods(ctx context.Context, selectors []labels.Selector, nodeNames []string) (*corev1.PodList, error) {
+	nodeList, err := s.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	nodes := make(map[string]*corev1.Node, len(nodeList))
+	for _, node := range nodeList {
+		nodes[node.Name] = node
+	}
+	nodeNames, nodeIPs := splitNodeIPs(nodeNames)
+	matchedNodes := sets.New(nodeNames...)
+	if len(selectors) > 0 {
+		matchedNodes = matchedNodes.Union(resolveNodeNames(ctx, nodes, selectors))
+	}
+	if len(nodeIPs) > 0 {
+		ipNodes, _ := nodeNamesByInternalIP(nodes, nodeIPs)
+		matchedNodes = matchedNodes.Union(ipNodes)
+	}
+
+	podNodes := matchedNodes
+	if s.includeUnscheduled {
+		// pods without a node have an empty spec.nodeName
+		podNodes = matchedNodes.Clone().Insert("")
+	}
+	var resp metav1.Table
+	for node := range podNodes {
+		pods, err := s.pods.ByIndex(podNodeNameIndex, node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods on node %q: %w", node, err)
+		}
+		for _, obj := range pods {
+			if pod := obj.(*corev1.Pod); s.podLabels.Matches(labels.Set(pod.Labels)) {
+				resp.Rows = append(resp.Rows, metav1.TableRow{Object: runtime.RawExtension{Object: pod}})
+			}
+		}
+	}
+	resp = s.filters.apply(resp, time.Now())
+	slices.SortFunc(resp.Rows, cmpPodRow)
+	klog.V(2).Infof("query matched %d pods on %d nodes", len(resp.Rows), matchedNodes.Len())
+
+	// the pods are copied, so the cached objects are not modified
+	list := toPodList(resp, podListOpts{})
+	list.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	return list, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodsServer(t *testing.T) {
+	nodeStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"pool": "general"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"pool": "general"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{"pool": "gpu"}},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.3"}}}},
+	} {
+		require.NoError(t, nodeStore.Add(node))
+	}
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{podNodeNameIndex: podNodeName})
+	for _, pod := range []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "b", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}}, Spec: corev1.PodSpec{NodeName: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a", Labels: map[string]string{"app": "web"}}, Spec: corev1.PodSpec{NodeName: "node2"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "c"}, Spec: corev1.PodSpec{NodeName: "node3"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "ds", OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds"}}},
+			Spec: corev1.PodSpec{NodeName: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "pending"}},
+	} {
+		require.NoError(t, podStore.Add(pod))
+	}
+	newServer := func(s *podsServer) *httptest.Server {
+		s.pods, s.nodes = podStore, corev1listers.NewNodeLister(nodeStore)
+		srv := httptest.NewServer(s.handler())
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	srv := newServer(&podsServer{podLabels: labels.Everything()})
+
+	get := func(t *testing.T, srv *httptest.Server, query string) (int, []string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/pods?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var list corev1.PodList
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		require.Equal(t, "PodList", list.Kind)
+		var names []string
+		for _, pod := range list.Items {
+			require.Empty(t, pod.ManagedFields)
+			names = append(names, pod.Spec.NodeName+"/"+pod.Name)
+		}
+		return resp.StatusCode, names
+	}
+
+	t.Run("selector", func(t *testing.T) {
+		code, pods := get(t, srv, "arg=pool%3Dgeneral")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"node1/b", "node2/a"}, pods, "sorted by node, daemonset pods filtered out")
+	})
+	t.Run("node names and IPs", func(t *testing.T) {
+		code, pods := get(t, srv, "arg=node2&arg=10.0.0.3")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"node2/a", "node3/c"}, pods)
+	})
+	t.Run("all nodes", func(t *testing.T) {
+		code, pods := get(t, srv, "all-nodes=true")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"node1/b", "node2/a", "node3/c"}, pods)
+	})
+	t.Run("no args", func(t *testing.T) {
+		code, _ := get(t, srv, "")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+	t.Run("pod selector", func(t *testing.T) {
+		code, pods := get(t, newServer(&podsServer{podLabels: labels.SelectorFromSet(labels.Set{"app": "web"})}), "all-nodes=true")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"node2/a"}, pods)
+	})
+	t.Run("include unscheduled", func(t *testing.T) {
+		code, pods := get(t, newServer(&podsServer{podLabels: labels.Everything(), includeUnscheduled: true}), "arg=node2")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"/pending", "node2/a"}, pods)
+	})
+	t.Run("cached objects are not modified", func(t *testing.T) {
+		pod, err := corev1listers.NewPodLister(podStore).Pods("ns1").Get("b")
+		require.NoError(t, err)
+		require.NotEmpty(t, pod.ManagedFields)
+	})
+}
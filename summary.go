@@ -0,0 +1,152 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// countByKeys are the --count-by dimensions and the functions extracting the
+// group key of a pod for them.
+var countByKeys = map[string]func(*corev1.Pod) string{
+	"node":       func(p *corev1.Pod) string { return p.Spec.NodeName },
+	"namespace":  func(p *corev1.Pod) string { return p.Namespace },
+	"phase":      func(p *corev1.Pod) string { return string(p.Status.Phase) },
+	"owner-kind": podOwnerKind,
+}
+
+// podOwnerKind returns the kind of the pod's controller, or empty if the pod
+// isn't controlled by a workload.
+func podOwnerKind(p *corev1.Pod) string {
+	if ref := metav1.GetControllerOf(p); ref != nil {
+		return ref.Kind
+	}
+	return ""
+}
+
+// podCount is the number of pods in a group in the summary output.
+type podCount struct {
+	key  string
+	pods int
+}
+
+// countPodsBy returns the number of pods in each group of the --count-by
+// dimension, sorted by descending count and then by key.
+func countPodsBy(resp metav1.Table, by string) ([]podCount, error) {
+	keyFn, ok := countByKeys[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown --count-by value %q (expected node, namespace, phase or owner-kind)", by)
+	}
+	counts := make(map[string]int)
+	for _, row := range resp.Rows {
+		counts[keyFn(row.Object.Object.(*corev1.Pod))]++
+	}
+	out := make([]podCount, 0, len(counts))
+	for key, n := range counts {
+		out = append(out, podCount{key: key, pods: n})
+	}
+	slices.SortFunc(out, func(a, b podCount) int {
+		if a.pods != b.pods {
+			return b.pods - a.pods
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	return out, nil
+}
+
+// topNodeCounts returns the first n of the pod counts by node (sorted by
+// countPodsBy), skipping the unscheduled pods.
+func topNodeCounts(counts []podCount, n int) []podCount {
+	out := make([]podCount, 0, n)
+	for _, c := range counts {
+		if len(out) == n {
+			break
+		}
+		if c.key != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// isStructuredFormat returns whether the output format is json or yaml, in
+// which the summary is printed as a structured array.
+func isStructuredFormat(outputFormat string) bool {
+	return outputFormat == "json" || outputFormat == "yaml"
+}
+
+// isSummaryFormat returns whether the pod counts can be printed in the output
+// format, as a table or as structured objects.
+func isSummaryFormat(outputFormat string) bool {
+	switch outputFormat {
+	case "", "wide", "json", "yaml", "jsonl", "ndjson":
+		return true
+	}
+	return false
+}
+
+// printSummary prints the pod counts grouped by the given dimension to w as a
+// json/yaml array (e.g. [{"node": "n1", "pods": 12}]) if the output format is
+// structured, as one json object per line in jsonl format, or as a table
+// otherwise.
+func printSummary(w io.Writer, counts []podCount, by, outputFormat string) error {
+	items := make([]map[string]interface{}, 0, len(counts))
+	for _, c := range counts {
+		items = append(items, map[string]interface{}{by: c.key, "pods": c.pods})
+	}
+	if outputFormat == "jsonl" || outputFormat == "ndjson" {
+		enc := json.NewEncoder(w)
+		for _, item := range items {
+			if err := enc.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if isStructuredFormat(outputFormat) {
+		var b []byte
+		var err error
+		if outputFormat == "json" {
+			b, err = json.MarshalIndent(items, "", "    ")
+			b = append(b, '\n')
+		} else {
+			b, err = yaml.Marshal(items)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintf(tw, "%s\tPODS\n", strings.ToUpper(by))
+	for _, c := range counts {
+		key := c.key
+		if key == "" {
+			key = "<none>"
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", key, c.pods)
+	}
+	return tw.Flush()
+}
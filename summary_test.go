@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+func TestCountPodsBy(t *testing.T) {
+	pod := func(node, ns string, phase corev1.PodPhase, ownerKind string) metav1.TableRow {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if ownerKind != "" {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: "x", Controller: ptr.To(true)}}
+		}
+		return metav1.TableRow{Object: runtime.RawExtension{Object: p}}
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		pod("node2", "ns1", corev1.PodRunning, "ReplicaSet"),
+		pod("node1", "ns2", corev1.PodRunning, "DaemonSet"),
+		pod("node2", "ns2", corev1.PodPending, "ReplicaSet"),
+		pod("", "ns1", corev1.PodPending, ""),
+		pod("node2", "ns2", corev1.PodRunning, "StatefulSet"),
+	}}
+
+	for by, want := range map[string][]podCount{
+		"node":       {{"node2", 3}, {"", 1}, {"node1", 1}},
+		"namespace":  {{"ns2", 3}, {"ns1", 2}},
+		"phase":      {{"Running", 3}, {"Pending", 2}},
+		"owner-kind": {{"ReplicaSet", 2}, {"", 1}, {"DaemonSet", 1}, {"StatefulSet", 1}},
+	} {
+		got, err := countPodsBy(resp, by)
+		require.NoError(t, err, by)
+		require.Equal(t, want, got, by)
+	}
+	_, err := countPodsBy(resp, "label")
+	require.ErrorContains(t, err, `"label"`)
+}
+
+func TestTopNodeCounts(t *testing.T) {
+	var rows []metav1.TableRow
+	for node, pods := range map[string]int{"node-c": 2, "node-a": 3, "node-b": 2, "node-d": 1, "": 5} {
+		for i := 0; i < pods; i++ {
+			rows = append(rows, metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{Spec: corev1.PodSpec{NodeName: node}}}})
+		}
+	}
+	counts, err := countPodsBy(metav1.Table{Rows: rows}, "node")
+	require.NoError(t, err)
+
+	// ties are sorted by node name, unscheduled pods are skipped
+	require.Equal(t, []podCount{{"node-a", 3}, {"node-b", 2}}, topNodeCounts(counts, 2))
+	require.Equal(t, []podCount{{"node-a", 3}, {"node-b", 2}, {"node-c", 2}, {"node-d", 1}}, topNodeCounts(counts, 10))
+
+	var b bytes.Buffer
+	require.NoError(t, printSummary(&b, topNodeCounts(counts, 1), "node", "json"))
+	require.JSONEq(t, `[{"node": "node-a", "pods": 3}]`, b.String())
+}
+
+func TestPrintSummary(t *testing.T) {
+	counts := []podCount{{"node2", 3}, {"", 1}, {"node1", 1}}
+
+	t.Run("text", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, printSummary(&b, counts, "node", ""))
+		require.Equal(t, "NODE     PODS\nnode2    3\n<none>   1\nnode1    1\n", b.String())
+
+		b.Reset()
+		require.NoError(t, printSummary(&b, []podCount{{"Running", 2}}, "phase", ""))
+		require.Equal(t, "PHASE     PODS\nRunning   2\n", b.String())
+	})
+	t.Run("json", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, printSummary(&b, counts, "node", "json"))
+		require.JSONEq(t, `[{"node":"node2","pods":3},{"node":"","pods":1},{"node":"node1","pods":1}]`, b.String())
+
+		b.Reset()
+		require.NoError(t, printSummary(&b, []podCount{{"ns1", 2}}, "namespace", "json"))
+		require.JSONEq(t, `[{"namespace":"ns1","pods":2}]`, b.String())
+	})
+	t.Run("yaml", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, printSummary(&b, counts, "node", "yaml"))
+		require.Equal(t, "- node: node2\n  pods: 3\n- node: \"\"\n  pods: 1\n- node: node1\n  pods: 1\n", b.String())
+	})
+	t.Run("jsonl", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, printSummary(&b, counts, "node", "jsonl"))
+		require.Equal(t, "{\"node\":\"node2\",\"pods\":3}\n{\"node\":\"\",\"pods\":1}\n{\"node\":\"node1\",\"pods\":1}\n", b.String())
+
+		b.Reset()
+		require.NoError(t, printSummary(&b, []podCount{{"ns1", 2}}, "namespace", "jsonl"))
+		require.Equal(t, "{\"namespace\":\"ns1\",\"pods\":2}\n", b.String())
+	})
+	t.Run("empty", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, printSummary(&b, nil, "node", "json"))
+		require.JSONEq(t, `[]`, b.String())
+	})
+}
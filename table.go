@@ -15,24 +15,460 @@
 package main
 
 import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
+// tableOpts controls the additional columns added by enhanceTable.
+type tableOpts struct {
+	nodeLabelColumns []string // node label keys to show as columns
+	showNodeStatus   bool     // show the Ready condition of the pod's node
+	showNodeTaints   bool     // show the taints of the pod's node
+	showLastEvent    bool     // show the last event of the pod
+	showScheduling   bool     // show the scheduler name and non-default tolerations
+	showContainers   bool     // show the container names
+	initContainers   bool     // include the init containers in the container names
+	showUID          bool     // show the pod UID
+	showTopology     bool     // show the zone and region labels of the pod's node
+	fullUID          bool     // don't truncate the pod UID
+	truncate         int      // truncate the node, namespace and pod names to this length (if positive)
+	nodeColumnsLast  bool     // append the Context, Node, node and Namespace columns instead of prepending them
+
+	// lastEvents is the last event of each pod (by pod UID)
+	lastEvents map[types.UID]string
+
+	// nodes is used for node columns (keyed by nodeKey), and may not contain
+	// all the nodes
+	nodes map[string]*corev1.Node
+
+	// matchedContainers is the containers matching the --image filter of each
+	// pod (by pod UID), shown as a column if set
+	matchedContainers map[types.UID]string
+
+	// podContexts is the kubeconfig context of each pod (by pod UID) when
+	// querying multiple contexts, shown as the first column if set
+	podContexts map[types.UID]string
+
+	// recentRestarts is the pods with a recently restarted container (by pod
+	// UID), marked with a * after their name unless highlighted in color
+	recentRestarts sets.Set[types.UID]
+}
+
+// nodeKey returns the key of a node in the maps of nodes: its name, prefixed
+// with its context when querying multiple contexts (whose node names may
+// collide).
+func nodeKey(contextName, nodeName string) string {
+	if contextName == "" {
+		return nodeName
+	}
+	return contextName + "/" + nodeName
+}
+
+// needsNodes returns whether nodes need to be fetched for the table columns.
+func (o tableOpts) needsNodes() bool {
+	return len(o.nodeLabelColumns) > 0 || o.showNodeStatus || o.showNodeTaints || o.showTopology
+}
+
+// metadataOnly returns whether the columns only need the pods' metadata and
+// node name. Only the options listed here are known to, setting any other
+// option needs the full pods.
+func (o tableOpts) metadataOnly() bool {
+	return reflect.DeepEqual(o, tableOpts{
+		nodeLabelColumns: o.nodeLabelColumns,
+		showNodeStatus:   o.showNodeStatus,
+		showNodeTaints:   o.showNodeTaints,
+		showLastEvent:    o.showLastEvent,
+		showUID:          o.showUID,
+		showTopology:     o.showTopology,
+		fullUID:          o.fullUID,
+		truncate:         o.truncate,
+		nodeColumnsLast:  o.nodeColumnsLast,
+		lastEvents:       o.lastEvents,
+		nodes:            o.nodes,
+		podContexts:      o.podContexts,
+	})
+}
+
 // enhanceTable adds additional information to the table like NODE and NAMESPACE
-// columns.
-func enhanceTable(in metav1.Table) metav1.Table {
-	// Define Node and Namespace columns
-	in.ColumnDefinitions = append([]metav1.TableColumnDefinition{
-		{Name: "Node", Type: "string", Priority: 0},
-		{Name: "Namespace", Type: "string", Priority: 0},
-	}, in.ColumnDefinitions...)
-
-	// Add Node and Namespace values to each row
+// columns, and optionally the node's status and labels.
+func enhanceTable(in metav1.Table, opts tableOpts) metav1.Table {
+	// The wide pod table of the server already has a Node column (and other
+	// tables may have a Namespace column), which would be duplicated
+	in = withoutColumns(in, "Node", "Namespace")
+
+	// Define Context, Node, node status, node label and Namespace columns
+	var columns []metav1.TableColumnDefinition
+	if opts.podContexts != nil {
+		columns = append(columns, metav1.TableColumnDefinition{Name: "Context", Type: "string", Priority: 0})
+	}
+	columns = append(columns, metav1.TableColumnDefinition{Name: "Node", Type: "string", Priority: 0})
+	if opts.showNodeStatus {
+		columns = append(columns, metav1.TableColumnDefinition{Name: "NodeStatus", Type: "string", Priority: 0})
+	}
+	if opts.showNodeTaints {
+		columns = append(columns, metav1.TableColumnDefinition{Name: "Taints", Type: "string", Priority: 0})
+	}
+	for _, key := range opts.nodeLabelColumns {
+		columns = append(columns, metav1.TableColumnDefinition{Name: labelColumnName(key), Type: "string", Priority: 0})
+	}
+	columns = append(columns, metav1.TableColumnDefinition{Name: "Namespace", Type: "string", Priority: 0})
+	nameColumn := -1
+	for i, col := range in.ColumnDefinitions {
+		if col.Name == "Name" {
+			nameColumn = i
+			break
+		}
+	}
+	if !opts.nodeColumnsLast {
+		in.ColumnDefinitions = append(columns, in.ColumnDefinitions...)
+	}
+	if opts.showLastEvent {
+		in.ColumnDefinitions = append(in.ColumnDefinitions, metav1.TableColumnDefinition{Name: "Last Event", Type: "string", Priority: 0})
+	}
+	if opts.matchedContainers != nil {
+		in.ColumnDefinitions = append(in.ColumnDefinitions, metav1.TableColumnDefinition{Name: "Matched-Container", Type: "string", Priority: 0})
+	}
+	if opts.showScheduling {
+		in.ColumnDefinitions = append(in.ColumnDefinitions,
+			metav1.TableColumnDefinition{Name: "Scheduler", Type: "string", Priority: 0},
+			metav1.TableColumnDefinition{Name: "Tolerations", Type: "string", Priority: 0})
+	}
+	if opts.showContainers {
+		in.ColumnDefinitions = append(in.ColumnDefinitions, metav1.TableColumnDefinition{Name: "Containers", Type: "string", Priority: 0})
+	}
+	if opts.showUID {
+		in.ColumnDefinitions = append(in.ColumnDefinitions, metav1.TableColumnDefinition{Name: "UID", Type: "string", Priority: 0})
+	}
+	if opts.showTopology {
+		in.ColumnDefinitions = append(in.ColumnDefinitions,
+			metav1.TableColumnDefinition{Name: "Zone", Type: "string", Priority: 0},
+			metav1.TableColumnDefinition{Name: "Region", Type: "string", Priority: 0})
+	}
+	if opts.nodeColumnsLast {
+		in.ColumnDefinitions = append(in.ColumnDefinitions, columns...)
+	}
+
+	// Add Context, Node, node status, node label and Namespace values to each row
 	for i := range in.Rows {
 		pod := in.Rows[i].Object.Object.(*corev1.Pod)
-		in.Rows[i].Cells = append([]interface{}{pod.Spec.NodeName, pod.Namespace}, in.Rows[i].Cells...)
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" {
+			nodeName = "<none>" // unscheduled
+		}
+		node := opts.nodes[nodeKey(opts.podContexts[pod.UID], pod.Spec.NodeName)]
+		var cells []interface{}
+		if opts.podContexts != nil {
+			cells = append(cells, opts.podContexts[pod.UID])
+		}
+		cells = append(cells, truncateCell(nodeName, opts.truncate))
+		if opts.showNodeStatus {
+			cells = append(cells, nodeReadyStatus(node))
+		}
+		if opts.showNodeTaints {
+			cells = append(cells, formatTaints(node))
+		}
+		for _, key := range opts.nodeLabelColumns {
+			var v string
+			if node != nil {
+				v = node.Labels[key]
+			}
+			cells = append(cells, v)
+		}
+		cells = append(cells, truncateCell(pod.Namespace, opts.truncate))
+		c := nameColumn
+		if !opts.nodeColumnsLast {
+			in.Rows[i].Cells = append(cells, in.Rows[i].Cells...)
+			c += len(cells)
+		}
+		if nameColumn >= 0 && c < len(in.Rows[i].Cells) {
+			if opts.truncate > 0 {
+				in.Rows[i].Cells[c] = truncateCell(fmt.Sprint(in.Rows[i].Cells[c]), opts.truncate)
+			}
+			if opts.recentRestarts.Has(pod.UID) {
+				in.Rows[i].Cells[c] = fmt.Sprint(in.Rows[i].Cells[c]) + "*"
+			}
+		}
+		if opts.showLastEvent {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, opts.lastEvents[pod.UID])
+		}
+		if opts.matchedContainers != nil {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, opts.matchedContainers[pod.UID])
+		}
+		if opts.showScheduling {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, pod.Spec.SchedulerName, formatTolerations(pod.Spec.Tolerations))
+		}
+		if opts.showContainers {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, containerNames(pod, opts.initContainers))
+		}
+		if opts.showUID {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, formatUID(pod.UID, opts.fullUID))
+		}
+		if opts.showTopology {
+			var zone, region string
+			if node != nil {
+				zone, region = node.Labels[corev1.LabelTopologyZone], node.Labels[corev1.LabelTopologyRegion]
+			}
+			in.Rows[i].Cells = append(in.Rows[i].Cells, zone, region)
+		}
+		if opts.nodeColumnsLast {
+			in.Rows[i].Cells = append(in.Rows[i].Cells, cells...)
+		}
 	}
 
 	return in
 }
+
+// withoutColumns returns the table without the columns with the given names
+// (case-insensitive) and their cells.
+func withoutColumns(in metav1.Table, names ...string) metav1.Table {
+	var drop []int
+	for i, col := range in.ColumnDefinitions {
+		if slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(col.Name, name) }) {
+			drop = append(drop, i)
+		}
+	}
+	if len(drop) == 0 {
+		return in
+	}
+	columns := make([]metav1.TableColumnDefinition, 0, len(in.ColumnDefinitions)-len(drop))
+	for i, col := range in.ColumnDefinitions {
+		if !slices.Contains(drop, i) {
+			columns = append(columns, col)
+		}
+	}
+	rows := make([]metav1.TableRow, len(in.Rows))
+	for r, row := range in.Rows {
+		cells := make([]interface{}, 0, len(row.Cells))
+		for i, cell := range row.Cells {
+			if !slices.Contains(drop, i) {
+				cells = append(cells, cell)
+			}
+		}
+		row.Cells = cells
+		rows[r] = row
+	}
+	in.ColumnDefinitions = columns
+	in.Rows = rows
+	return in
+}
+
+// columnKey normalizes a column name for matching the --columns values, so
+// "Last Event" is matched by "last-event" (or "LAST EVENT").
+func columnKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+}
+
+// selectColumns returns the table with only the named columns (matched by
+// columnKey) in their original order, removing the cells of the other
+// columns from each row. The selected columns are shown regardless of their
+// priority (e.g. IP without -o wide).
+func selectColumns(in metav1.Table, names []string) (metav1.Table, error) {
+	want := sets.New[string]()
+	for _, name := range names {
+		want.Insert(columnKey(name))
+	}
+	var keep []int
+	found := sets.New[string]()
+	for i, col := range in.ColumnDefinitions {
+		if key := columnKey(col.Name); want.Has(key) {
+			keep = append(keep, i)
+			found.Insert(key)
+		}
+	}
+	if unknown := want.Difference(found); unknown.Len() > 0 {
+		available := make([]string, 0, len(in.ColumnDefinitions))
+		for _, col := range in.ColumnDefinitions {
+			available = append(available, columnKey(col.Name))
+		}
+		return in, fmt.Errorf("unknown columns: %s (available: %s)",
+			strings.Join(sets.List(unknown), ", "), strings.Join(available, ", "))
+	}
+
+	columns := make([]metav1.TableColumnDefinition, 0, len(keep))
+	for _, i := range keep {
+		col := in.ColumnDefinitions[i]
+		col.Priority = 0
+		columns = append(columns, col)
+	}
+	rows := make([]metav1.TableRow, len(in.Rows))
+	for r, row := range in.Rows {
+		if len(row.Cells) < len(in.ColumnDefinitions) {
+			// the cells can't be matched to the columns
+			return in, fmt.Errorf("row %d has %d cells for %d columns", r, len(row.Cells), len(in.ColumnDefinitions))
+		}
+		cells := make([]interface{}, 0, len(keep))
+		for _, i := range keep {
+			cells = append(cells, row.Cells[i])
+		}
+		row.Cells = cells
+		rows[r] = row
+	}
+	in.ColumnDefinitions = columns
+	in.Rows = rows
+	return in, nil
+}
+
+// truncateCell shortens s to n characters (not bytes) ending with an
+// ellipsis if it's longer, or returns it as is if n is not positive.
+func truncateCell(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// shortUIDLength is the length of the UIDs shown without --full-uid, which is
+// enough to tell apart the pods in a listing.
+const shortUIDLength = 8
+
+func formatUID(uid types.UID, full bool) string {
+	if full || len(uid) <= shortUIDLength {
+		return string(uid)
+	}
+	return string(uid[:shortUIDLength])
+}
+
+// recentlyRestartedPods returns the UIDs of the pods with a container that
+// restarted after since: its last run finished, or it started running again.
+func recentlyRestartedPods(resp metav1.Table, since time.Time) sets.Set[types.UID] {
+	out := sets.New[types.UID]()
+	for _, row := range resp.Rows {
+		pod := row.Object.Object.(*corev1.Pod)
+		if restartedSince(pod, since) {
+			out.Insert(pod.UID)
+		}
+	}
+	return out
+}
+
+func restartedSince(pod *corev1.Pod, since time.Time) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if t := cs.LastTerminationState.Terminated; t != nil && t.FinishedAt.After(since) {
+			return true
+		}
+		// a container that never restarted started with the pod
+		if r := cs.State.Running; r != nil && cs.RestartCount > 0 && r.StartedAt.After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeReadyStatus returns Ready, NotReady or Unknown based on the node's Ready
+// condition. Nodes that are nil (i.e. not fetched) are Unknown.
+func nodeReadyStatus(node *corev1.Node) string {
+	if node == nil {
+		return "Unknown"
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != corev1.NodeReady {
+			continue
+		}
+		switch cond.Status {
+		case corev1.ConditionTrue:
+			return "Ready"
+		case corev1.ConditionFalse:
+			return "NotReady"
+		}
+	}
+	return "Unknown"
+}
+
+// formatTaints returns the taints of the node as comma-separated
+// key=value:effect (or key:effect) strings, or empty for nil nodes.
+func formatTaints(node *corev1.Node) string {
+	if node == nil {
+		return ""
+	}
+	taints := make([]string, 0, len(node.Spec.Taints))
+	for _, t := range node.Spec.Taints {
+		taints = append(taints, t.ToString())
+	}
+	return strings.Join(taints, ",")
+}
+
+// nodePressureConditions are the node conditions that indicate resource
+// pressure.
+var nodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+}
+
+// nodePressure returns the number of the pressure conditions that are true
+// for the node (0 for nil nodes).
+func nodePressure(node *corev1.Node) int {
+	if node == nil {
+		return 0
+	}
+	var n int
+	for _, cond := range node.Status.Conditions {
+		if cond.Status == corev1.ConditionTrue && slices.Contains(nodePressureConditions, cond.Type) {
+			n++
+		}
+	}
+	return n
+}
+
+// labelColumnName returns the column name for a label key, which is the last
+// segment of the key (e.g. "zone" for "topology.kubernetes.io/zone"), similar
+// to kubectl's --label-columns.
+func labelColumnName(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
+
+// containerNames returns the comma-separated names of the pod's containers in
+// spec order, preceded by the init containers (prefixed with "init:") if
+// includeInit is set.
+func containerNames(pod *corev1.Pod, includeInit bool) string {
+	var names []string
+	if includeInit {
+		for _, c := range pod.Spec.InitContainers {
+			names = append(names, "init:"+c.Name)
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// defaultTolerationKeys are the taint keys that the DefaultTolerationSeconds
+// admission plugin adds tolerations for to every pod.
+var defaultTolerationKeys = map[string]bool{
+	corev1.TaintNodeNotReady:    true,
+	corev1.TaintNodeUnreachable: true,
+}
+
+// formatTolerations returns the comma-separated keys of the tolerations that
+// aren't added by default, or <none>. A toleration with an empty key (which
+// tolerates all taints) is shown as "*".
+func formatTolerations(tolerations []corev1.Toleration) string {
+	var keys []string
+	for _, t := range tolerations {
+		if defaultTolerationKeys[t.Key] && t.Effect == corev1.TaintEffectNoExecute {
+			continue
+		}
+		key := t.Key
+		if key == "" {
+			key = "*"
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return "<none>"
+	}
+	return strings.Join(keys, ",")
+}
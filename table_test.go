@@ -0,0 +1,376 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestEnhanceTable(t *testing.T) {
+	pod := func(node, ns string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns}, Spec: corev1.PodSpec{NodeName: node}}
+	}
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: pod("node1", "ns1")}},
+			{Cells: []interface{}{"b"}, Object: runtime.RawExtension{Object: pod("node2", "ns2")}},
+		},
+	}
+
+	t.Run("node label columns", func(t *testing.T) {
+		out := enhanceTable(*in.DeepCopy(), tableOpts{
+			nodeLabelColumns: []string{"topology.kubernetes.io/zone", "pool"},
+			nodes: map[string]*corev1.Node{
+				"node1": {ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"topology.kubernetes.io/zone": "us-west1-a", "pool": "default"},
+				}},
+			},
+		})
+
+		var names []string
+		for _, c := range out.ColumnDefinitions {
+			names = append(names, c.Name)
+		}
+		require.Equal(t, []string{"Node", "zone", "pool", "Namespace", "Name"}, names)
+		require.Equal(t, []interface{}{"node1", "us-west1-a", "default", "ns1", "a"}, out.Rows[0].Cells)
+		require.Equal(t, []interface{}{"node2", "", "", "ns2", "b"}, out.Rows[1].Cells)
+	})
+	t.Run("no node label columns", func(t *testing.T) {
+		out := enhanceTable(*in.DeepCopy(), tableOpts{})
+		require.Len(t, out.ColumnDefinitions, 3)
+		require.Equal(t, []interface{}{"node1", "ns1", "a"}, out.Rows[0].Cells)
+	})
+	t.Run("node status column", func(t *testing.T) {
+		node := func(status corev1.ConditionStatus) *corev1.Node {
+			return &corev1.Node{Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+				{Type: corev1.NodeReady, Status: status},
+			}}}
+		}
+		out := enhanceTable(metav1.Table{Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: pod("node1", "ns")}},
+			{Cells: []interface{}{"b"}, Object: runtime.RawExtension{Object: pod("node2", "ns")}},
+			{Cells: []interface{}{"c"}, Object: runtime.RawExtension{Object: pod("node3", "ns")}},
+			{Cells: []interface{}{"d"}, Object: runtime.RawExtension{Object: pod("node4", "ns")}},
+			{Cells: []interface{}{"e"}, Object: runtime.RawExtension{Object: pod("node5", "ns")}},
+		}}, tableOpts{
+			showNodeStatus: true,
+			nodes: map[string]*corev1.Node{
+				"node1": node(corev1.ConditionTrue),
+				"node2": node(corev1.ConditionFalse),
+				"node3": node(corev1.ConditionUnknown),
+				"node4": {},
+			},
+		})
+		require.Equal(t, "NodeStatus", out.ColumnDefinitions[1].Name)
+		var statuses []interface{}
+		for _, row := range out.Rows {
+			statuses = append(statuses, row.Cells[1])
+		}
+		require.Equal(t, []interface{}{"Ready", "NotReady", "Unknown", "Unknown", "Unknown"}, statuses)
+	})
+	t.Run("unscheduled pod", func(t *testing.T) {
+		out := enhanceTable(metav1.Table{Rows: []metav1.TableRow{
+			{Cells: []interface{}{"c"}, Object: runtime.RawExtension{Object: pod("", "ns1")}},
+		}}, tableOpts{})
+		require.Equal(t, []interface{}{"<none>", "ns1", "c"}, out.Rows[0].Cells)
+	})
+	t.Run("scheduling columns", func(t *testing.T) {
+		p := pod("node1", "ns1")
+		p.Spec.SchedulerName = "custom-scheduler"
+		p.Spec.Tolerations = []corev1.Toleration{
+			{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			{Key: corev1.TaintNodeUnreachable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+		}
+		p2 := pod("node1", "ns1")
+		p2.Spec.SchedulerName = "default-scheduler"
+		p2.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+		out := enhanceTable(metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+			Rows: []metav1.TableRow{
+				{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: p}},
+				{Cells: []interface{}{"b"}, Object: runtime.RawExtension{Object: p2}},
+				{Cells: []interface{}{"c"}, Object: runtime.RawExtension{Object: pod("node1", "ns1")}},
+			},
+		}, tableOpts{showScheduling: true})
+		var names []string
+		for _, c := range out.ColumnDefinitions {
+			names = append(names, c.Name)
+		}
+		require.Equal(t, []string{"Node", "Namespace", "Name", "Scheduler", "Tolerations"}, names)
+		require.Equal(t, []interface{}{"node1", "ns1", "a", "custom-scheduler", "dedicated"}, out.Rows[0].Cells)
+		require.Equal(t, []interface{}{"node1", "ns1", "b", "default-scheduler", "*"}, out.Rows[1].Cells)
+		require.Equal(t, []interface{}{"node1", "ns1", "c", "", "<none>"}, out.Rows[2].Cells)
+	})
+	t.Run("context column", func(t *testing.T) {
+		p := pod("node1", "ns1")
+		p.UID = "uid1"
+		out := enhanceTable(metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+			Rows:              []metav1.TableRow{{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: p}}},
+		}, tableOpts{podContexts: map[types.UID]string{"uid1": "prod-us"}})
+		require.Equal(t, "Context", out.ColumnDefinitions[0].Name)
+		require.Equal(t, []interface{}{"prod-us", "node1", "ns1", "a"}, out.Rows[0].Cells)
+	})
+	t.Run("node taints column", func(t *testing.T) {
+		out := enhanceTable(metav1.Table{Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: pod("node1", "ns")}},
+			{Cells: []interface{}{"b"}, Object: runtime.RawExtension{Object: pod("node2", "ns")}},
+			{Cells: []interface{}{"c"}, Object: runtime.RawExtension{Object: pod("node3", "ns")}},
+		}}, tableOpts{
+			showNodeTaints: true,
+			nodes: map[string]*corev1.Node{
+				"node1": {Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+					{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+					{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoExecute},
+				}}},
+				"node2": {},
+			},
+		})
+		require.Equal(t, "Taints", out.ColumnDefinitions[1].Name)
+		require.Equal(t, "dedicated=gpu:NoSchedule,node.kubernetes.io/unreachable:NoExecute", out.Rows[0].Cells[1])
+		require.Equal(t, "", out.Rows[1].Cells[1])
+		require.Equal(t, "", out.Rows[2].Cells[1], "unknown node")
+	})
+	t.Run("matched container column", func(t *testing.T) {
+		p := pod("node1", "ns1")
+		p.UID = "uid1"
+		out := enhanceTable(metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+			Rows:              []metav1.TableRow{{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: p}}},
+		}, tableOpts{matchedContainers: map[types.UID]string{"uid1": "init:migrate"}})
+		require.Equal(t, "Matched-Container", out.ColumnDefinitions[3].Name)
+		require.Equal(t, []interface{}{"node1", "ns1", "a", "init:migrate"}, out.Rows[0].Cells)
+	})
+	t.Run("containers column", func(t *testing.T) {
+		p := pod("node1", "ns1")
+		p.Spec.InitContainers = []corev1.Container{{Name: "setup"}}
+		p.Spec.Containers = []corev1.Container{{Name: "sidecar"}, {Name: "app"}, {Name: "metrics"}}
+		in := metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+			Rows:              []metav1.TableRow{{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: p}}},
+		}
+
+		out := enhanceTable(*in.DeepCopy(), tableOpts{showContainers: true})
+		require.Equal(t, "Containers", out.ColumnDefinitions[3].Name)
+		require.Equal(t, []interface{}{"node1", "ns1", "a", "sidecar,app,metrics"}, out.Rows[0].Cells)
+
+		out = enhanceTable(*in.DeepCopy(), tableOpts{showContainers: true, initContainers: true})
+		require.Equal(t, "init:setup,sidecar,app,metrics", out.Rows[0].Cells[3])
+	})
+}
+
+func TestRecentlyRestartedPods(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-5 * time.Minute)
+	at := func(ago time.Duration) metav1.Time { return metav1.NewTime(now.Add(-ago)) }
+	pod := func(uid string, statuses ...corev1.ContainerStatus) metav1.TableRow {
+		return metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)},
+			Status:     corev1.PodStatus{ContainerStatuses: statuses},
+		}}}
+	}
+	resp := metav1.Table{Rows: []metav1.TableRow{
+		pod("terminated-recently", corev1.ContainerStatus{
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: at(time.Minute)}},
+		}),
+		pod("terminated-long-ago", corev1.ContainerStatus{
+			RestartCount:         1,
+			State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(time.Hour)}},
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: at(time.Hour)}},
+		}),
+		pod("restarted-recently", corev1.ContainerStatus{
+			RestartCount: 2,
+			State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(2 * time.Minute)}},
+		}),
+		pod("started-recently", corev1.ContainerStatus{
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(time.Minute)}},
+		}),
+		pod("second-container",
+			corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(time.Hour)}}},
+			corev1.ContainerStatus{LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: at(4 * time.Minute)}}},
+		),
+		pod("no-statuses"),
+	}}
+	require.Equal(t, []types.UID{"restarted-recently", "second-container", "terminated-recently"},
+		sets.List(recentlyRestartedPods(resp, since)))
+}
+
+func TestEnhanceTableRecentRestarts(t *testing.T) {
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}, {Name: "Status"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "uid-a", Namespace: "ns"}}}},
+			{Cells: []interface{}{"b", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "uid-b", Namespace: "ns"}}}},
+		},
+	}
+	out := enhanceTable(in, tableOpts{recentRestarts: sets.New[types.UID]("uid-b")})
+	require.Equal(t, []interface{}{"<none>", "ns", "a", "Running"}, out.Rows[0].Cells)
+	require.Equal(t, []interface{}{"<none>", "ns", "b*", "Running"}, out.Rows[1].Cells)
+}
+
+func TestEnhanceTableUID(t *testing.T) {
+	in := func() metav1.Table {
+		return metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+			Rows: []metav1.TableRow{
+				{Cells: []interface{}{"a"}, Object: runtime.RawExtension{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns", UID: "5f2b7c1e-8d4a-4b6e-9c3f-1a2b3c4d5e6f",
+				}}}},
+			},
+		}
+	}
+	out := enhanceTable(in(), tableOpts{showUID: true})
+	require.Equal(t, "UID", out.ColumnDefinitions[len(out.ColumnDefinitions)-1].Name)
+	require.Equal(t, []interface{}{"<none>", "ns", "a", "5f2b7c1e"}, out.Rows[0].Cells)
+
+	out = enhanceTable(in(), tableOpts{showUID: true, fullUID: true})
+	require.Equal(t, []interface{}{"<none>", "ns", "a", "5f2b7c1e-8d4a-4b6e-9c3f-1a2b3c4d5e6f"}, out.Rows[0].Cells)
+
+	require.Equal(t, "abc", formatUID("abc", false))
+}
+
+func TestTruncateCell(t *testing.T) {
+	require.Equal(t, "ip-10-0-0-1", truncateCell("ip-10-0-0-1", 0))
+	require.Equal(t, "ip-10-0-0-1", truncateCell("ip-10-0-0-1", 11))
+	require.Equal(t, "ip-10-0-0…", truncateCell("ip-10-0-0-1", 10))
+	require.Equal(t, "…", truncateCell("ab", 1))
+	// counted by characters, not bytes
+	require.Equal(t, "名前空間", truncateCell("名前空間", 4))
+	require.Equal(t, "名前…", truncateCell("名前空間", 3))
+}
+
+func TestEnhanceTableTruncate(t *testing.T) {
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}, {Name: "Status"}},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"web-7d9f8c6b5-abcde", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-payments", UID: "u1"},
+				Spec:       corev1.PodSpec{NodeName: "ip-10-0-0-1.ec2.internal"},
+			}}},
+		},
+	}
+	out := enhanceTable(in, tableOpts{truncate: 8, recentRestarts: sets.New[types.UID]("u1")})
+	require.Equal(t, []interface{}{"ip-10-0…", "team-pa…", "web-7d9…*", "Running"}, out.Rows[0].Cells)
+}
+
+func TestSelectColumns(t *testing.T) {
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Node"}, {Name: "Namespace"}, {Name: "Name"}, {Name: "Status"}, {Name: "IP", Priority: 1}, {Name: "Last Event"},
+		},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"node1", "ns1", "a", "Running", "10.0.0.1", "Pulled"}},
+			{Cells: []interface{}{"node2", "ns2", "b", "Pending", "10.0.0.2", ""}},
+		},
+	}
+
+	// dropping the middle columns keeps the cells aligned with the columns
+	out, err := selectColumns(in, []string{"node", "NAME", "ip", "last-event"})
+	require.NoError(t, err)
+	require.Equal(t, []metav1.TableColumnDefinition{{Name: "Node"}, {Name: "Name"}, {Name: "IP"}, {Name: "Last Event"}}, out.ColumnDefinitions)
+	require.Equal(t, []interface{}{"node1", "a", "10.0.0.1", "Pulled"}, out.Rows[0].Cells)
+	require.Equal(t, []interface{}{"node2", "b", "10.0.0.2", ""}, out.Rows[1].Cells)
+	require.Len(t, in.Rows[0].Cells, 6, "input rows are not modified")
+
+	_, err = selectColumns(in, []string{"name", "restarts"})
+	require.EqualError(t, err, "unknown columns: restarts (available: node, namespace, name, status, ip, last-event)")
+
+	// the cells of short rows can't be matched to the columns
+	in.Rows = append(in.Rows, metav1.TableRow{Cells: []interface{}{"node3", "ns3", "c"}})
+	_, err = selectColumns(in, []string{"name"})
+	require.EqualError(t, err, "row 2 has 3 cells for 6 columns")
+}
+
+func TestEnhanceTableNodeColumnPosition(t *testing.T) {
+	in := func() metav1.Table {
+		return metav1.Table{
+			ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}, {Name: "Status"}},
+			Rows: []metav1.TableRow{
+				{Cells: []interface{}{"a", "Running"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "ns", UID: "u1"},
+					Spec:       corev1.PodSpec{NodeName: "node1"},
+				}}},
+			},
+		}
+	}
+	columnNames := func(t metav1.Table) []string {
+		var out []string
+		for _, col := range t.ColumnDefinitions {
+			out = append(out, col.Name)
+		}
+		return out
+	}
+	opts := tableOpts{showNodeStatus: true, showUID: true, recentRestarts: sets.New[types.UID]("u1")}
+
+	out := enhanceTable(in(), opts)
+	require.Equal(t, []string{"Node", "NodeStatus", "Namespace", "Name", "Status", "UID"}, columnNames(out))
+	require.Equal(t, []interface{}{"node1", "Unknown", "ns", "a*", "Running", "u1"}, out.Rows[0].Cells)
+
+	opts.nodeColumnsLast = true
+	out = enhanceTable(in(), opts)
+	require.Equal(t, []string{"Name", "Status", "UID", "Node", "NodeStatus", "Namespace"}, columnNames(out))
+	require.Equal(t, []interface{}{"a*", "Running", "u1", "node1", "Unknown", "ns"}, out.Rows[0].Cells)
+}
+
+func TestEnhanceTableTopologyColumns(t *testing.T) {
+	row := func(name, node string) metav1.TableRow {
+		return metav1.TableRow{Cells: []interface{}{name}, Object: runtime.RawExtension{Object: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}}}
+	}
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}},
+		Rows:              []metav1.TableRow{row("a", "node1"), row("b", "node2"), row("c", "")},
+	}
+	nodes := map[string]*corev1.Node{
+		"node1": {ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{
+			corev1.LabelTopologyZone:   "us-east1-b",
+			corev1.LabelTopologyRegion: "us-east1",
+		}}},
+		"node2": {ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"pool": "general"}}},
+	}
+
+	out := enhanceTable(in, tableOpts{showTopology: true, nodes: nodes})
+	require.Equal(t, "Zone", out.ColumnDefinitions[len(out.ColumnDefinitions)-2].Name)
+	require.Equal(t, "Region", out.ColumnDefinitions[len(out.ColumnDefinitions)-1].Name)
+	require.Equal(t, []interface{}{"node1", "ns", "a", "us-east1-b", "us-east1"}, out.Rows[0].Cells)
+	require.Equal(t, []interface{}{"node2", "ns", "b", "", ""}, out.Rows[1].Cells, "node without topology labels")
+	require.Equal(t, []interface{}{"<none>", "ns", "c", "", ""}, out.Rows[2].Cells, "unscheduled pod")
+	require.True(t, tableOpts{showTopology: true}.needsNodes())
+}
+
+func TestEnhanceTableServerNodeColumn(t *testing.T) {
+	// the server's wide table has a Node column with priority 1
+	in := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name"}, {Name: "Status"}, {Name: "IP", Priority: 1}, {Name: "NODE", Priority: 1}, {Name: "Nominated Node", Priority: 1},
+		},
+		Rows: []metav1.TableRow{
+			{Cells: []interface{}{"a", "Running", "10.0.0.1", "node1", "<none>"}, Object: runtime.RawExtension{Object: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns"},
+				Spec:       corev1.PodSpec{NodeName: "node1"},
+			}}},
+		},
+	}
+	cells := in.Rows[0].Cells
+
+	out := enhanceTable(in, tableOpts{})
+	var names []string
+	for _, col := range out.ColumnDefinitions {
+		names = append(names, col.Name)
+	}
+	require.Equal(t, []string{"Node", "Namespace", "Name", "Status", "IP", "Nominated Node"}, names)
+	require.Zero(t, out.ColumnDefinitions[0].Priority)
+	require.Equal(t, []interface{}{"node1", "ns", "a", "Running", "10.0.0.1", "<none>"}, out.Rows[0].Cells)
+	require.Equal(t, []interface{}{"a", "Running", "10.0.0.1", "node1", "<none>"}, cells, "input rows are not modified")
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// phaseTimings are the durations of the phases of a run printed with
+// --verbose-timings.
+type phaseTimings struct {
+	listNodes      time.Duration // fetching nodes from the API
+	matchSelectors time.Duration // matching the nodes against the selectors
+	queryPods      time.Duration // querying the pods (across all attempts with --wait-for)
+	filter         time.Duration // deduping and filtering the queried pods
+	print          time.Duration
+	pages          int // pod list pages fetched
+}
+
+// writeTimings writes the phase durations and their total to w as a table.
+func writeTimings(w io.Writer, t phaseTimings) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tDURATION")
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"list nodes", t.listNodes},
+		{"match selectors", t.matchSelectors},
+		{fmt.Sprintf("query pods (%d pages)", t.pages), t.queryPods},
+		{"filter", t.filter},
+		{"print", t.print},
+		{"total", t.listNodes + t.matchSelectors + t.queryPods + t.filter + t.print},
+	} {
+		fmt.Fprintf(tw, "%s\t%v\n", phase.name, phase.d.Truncate(time.Millisecond))
+	}
+	return tw.Flush()
+}
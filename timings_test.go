@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTimings(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, writeTimings(&b, phaseTimings{
+		listNodes:      1200 * time.Millisecond,
+		matchSelectors: 3*time.Millisecond + 400*time.Microsecond,
+		queryPods:      2 * time.Second,
+		filter:         15 * time.Millisecond,
+		print:          800 * time.Microsecond,
+		pages:          4,
+	}))
+	require.Equal(t, `PHASE                  DURATION
+list nodes             1.2s
+match selectors        3ms
+query pods (4 pages)   2s
+filter                 15ms
+print                  0s
+total                  3.219s
+`, b.String())
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+)
+
+// connStats counts the requests made and the new connections they needed,
+// to verify connections are reused (with HTTP/2, all parallel requests
+// should be multiplexed over a single connection).
+type connStats struct {
+	requests atomic.Int64
+	newConns atomic.Int64
+
+	mu     sync.Mutex
+	protos map[string]int
+}
+
+// wrap returns a RoundTripper that records the stats of requests made
+// through rt, for use with rest.Config.Wrap.
+func (s *connStats) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if !info.Reused {
+					s.newConns.Add(1)
+				}
+			},
+		}
+		s.requests.Add(1)
+		resp, err := rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err == nil {
+			s.mu.Lock()
+			if s.protos == nil {
+				s.protos = make(map[string]int)
+			}
+			s.protos[resp.Proto]++
+			s.mu.Unlock()
+		}
+		return resp, err
+	})
+}
+
+// protocols returns the number of responses by protocol (e.g. "HTTP/2.0").
+func (s *connStats) protocols() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int, len(s.protos))
+	for k, v := range s.protos {
+		out[k] = v
+	}
+	return out
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var stats connStats
+	client := &http.Client{Transport: stats.wrap(http.DefaultTransport.(*http.Transport).Clone())}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	require.EqualValues(t, 5, stats.requests.Load())
+	require.EqualValues(t, 1, stats.newConns.Load(), "sequential requests should reuse the connection")
+	require.Equal(t, map[string]int{"HTTP/1.1": 5}, stats.protocols())
+}
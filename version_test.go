@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatVersion(t *testing.T) {
+	require.Equal(t, "kubectl-pods_on v1.2.3 (commit: 0841f7c, built: 2024-05-01T10:00:00Z)",
+		formatVersion("v1.2.3", "0841f7c", "2024-05-01T10:00:00Z"))
+	require.Equal(t, "kubectl-pods_on dev (commit: unknown, built: unknown)", formatVersion(version, commit, date))
+}
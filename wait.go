@@ -0,0 +1,86 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// errWaitTimeout is returned by waitForPods when the pods didn't satisfy the
+// condition in time.
+var errWaitTimeout = errors.New("timed out waiting for the pods")
+
+// podPhases are the valid --wait-for values.
+var podPhases = []corev1.PodPhase{corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown}
+
+// parsePodPhase parses a pod phase (case-insensitive).
+func parsePodPhase(s string) (corev1.PodPhase, error) {
+	for _, phase := range podPhases {
+		if strings.EqualFold(s, string(phase)) {
+			return phase, nil
+		}
+	}
+	return "", fmt.Errorf("unknown pod phase %q (expected Pending, Running, Succeeded, Failed or Unknown)", s)
+}
+
+// allPodsInPhase returns whether there are pods in the table, and all of them
+// are in the given phase.
+func allPodsInPhase(resp metav1.Table, phase corev1.PodPhase) bool {
+	if len(resp.Rows) == 0 {
+		return false
+	}
+	for _, row := range resp.Rows {
+		if row.Object.Object.(*corev1.Pod).Status.Phase != phase {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForPods calls query every interval until the pods it returns satisfy
+// done, and returns them. If they don't within the timeout, it returns the
+// last queried pods with errWaitTimeout. Query errors are returned
+// immediately.
+func waitForPods(ctx context.Context, query func() (metav1.Table, queryStats, error), done func(metav1.Table) bool, interval, timeout time.Duration) (metav1.Table, queryStats, error) {
+	deadline := time.Now().Add(timeout)
+	var stats queryStats
+	for attempt := 1; ; attempt++ {
+		resp, attemptStats, err := query()
+		stats.add(attemptStats)
+		if err != nil {
+			return resp, stats, err
+		}
+		if done(resp) {
+			return resp, stats, nil
+		}
+		klog.V(1).Infof("matched pods are not ready yet (attempt %d), querying again in %v", attempt, interval)
+		if time.Now().Add(interval).After(deadline) {
+			return resp, stats, errWaitTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return resp, stats, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
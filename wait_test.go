@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func podsInPhases(phases ...corev1.PodPhase) metav1.Table {
+	var out metav1.Table
+	for _, phase := range phases {
+		out.Rows = append(out.Rows, metav1.TableRow{Object: runtime.RawExtension{Object: &corev1.Pod{
+			Status: corev1.PodStatus{Phase: phase},
+		}}})
+	}
+	return out
+}
+
+func TestAllPodsInPhase(t *testing.T) {
+	require.True(t, allPodsInPhase(podsInPhases(corev1.PodRunning, corev1.PodRunning), corev1.PodRunning))
+	require.False(t, allPodsInPhase(podsInPhases(corev1.PodRunning, corev1.PodPending), corev1.PodRunning))
+	require.False(t, allPodsInPhase(podsInPhases(), corev1.PodRunning), "no pods matched yet")
+}
+
+func TestParsePodPhase(t *testing.T) {
+	phase, err := parsePodPhase("running")
+	require.NoError(t, err)
+	require.Equal(t, corev1.PodRunning, phase)
+	_, err = parsePodPhase("Ready")
+	require.Error(t, err)
+}
+
+func TestWaitForPods(t *testing.T) {
+	// successive query results
+	results := []metav1.Table{
+		podsInPhases(),
+		podsInPhases(corev1.PodPending, corev1.PodRunning),
+		podsInPhases(corev1.PodRunning, corev1.PodRunning),
+	}
+	var queries int
+	query := func() (metav1.Table, queryStats, error) {
+		resp := results[min(queries, len(results)-1)]
+		queries++
+		return resp, queryStats{pages: 1}, nil
+	}
+	done := func(t metav1.Table) bool { return allPodsInPhase(t, corev1.PodRunning) }
+
+	resp, stats, err := waitForPods(context.Background(), query, done, time.Millisecond, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 3, queries)
+	require.Equal(t, 3, stats.pages)
+	require.Len(t, resp.Rows, 2)
+
+	// times out with the last result
+	queries = 0
+	results = []metav1.Table{podsInPhases(corev1.PodPending)}
+	resp, _, err = waitForPods(context.Background(), query, done, 10*time.Millisecond, 25*time.Millisecond)
+	require.ErrorIs(t, err, errWaitTimeout)
+	require.GreaterOrEqual(t, queries, 2)
+	require.Equal(t, corev1.PodPending, resp.Rows[0].Object.Object.(*corev1.Pod).Status.Phase)
+
+	// query errors are returned right away
+	queries = 0
+	_, _, err = waitForPods(context.Background(), func() (metav1.Table, queryStats, error) {
+		queries++
+		return metav1.Table{}, queryStats{}, errors.New("forbidden")
+	}, done, time.Millisecond, time.Minute)
+	require.EqualError(t, err, "forbidden")
+	require.Equal(t, 1, queries)
+}
+
+func TestAllPodsInPhaseMetadataOnly(t *testing.T) {
+	// the pods parsed from a metadata-only table have no status, so --wait-for
+	// can only be satisfied by querying the full pods
+	raw, err := json.Marshal(&metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+	})
+	require.NoError(t, err)
+	tbl := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name"}, {Name: "Status"}, {Name: "Node"}},
+		Rows:              []metav1.TableRow{{Cells: []interface{}{"pod1", "Running", "node1"}, Object: runtime.RawExtension{Raw: raw}}},
+	}
+	require.NoError(t, parsePodMetadata(&tbl))
+	require.False(t, allPodsInPhase(tbl, corev1.PodRunning))
+}
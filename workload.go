@@ -0,0 +1,128 @@
+// Copyright 2024 Ahmet Alp Balkan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	typedappsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	"k8s.io/klog/v2"
+)
+
+// parseWorkloadRef parses a workload reference like deployment/name into its
+// normalized kind (deployment, statefulset or daemonset) and name.
+func parseWorkloadRef(ref string) (kind, name string, err error) {
+	kind, name, ok := strings.Cut(ref, "/")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid workload %q (expected <kind>/<name>, e.g. deployment/my-app)", ref)
+	}
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		return "deployment", name, nil
+	case "statefulset", "statefulsets", "sts":
+		return "statefulset", name, nil
+	case "daemonset", "daemonsets", "ds":
+		return "daemonset", name, nil
+	}
+	return "", "", fmt.Errorf("unsupported workload kind %q (expected deployment, statefulset or daemonset)", kind)
+}
+
+// getWorkloadPodSpec returns the pod template spec of the workload.
+func getWorkloadPodSpec(ctx context.Context, client typedappsv1.AppsV1Interface, namespace, kind, name string) (*corev1.PodSpec, error) {
+	switch kind {
+	case "deployment":
+		w, err := client.Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &w.Spec.Template.Spec, nil
+	case "statefulset":
+		w, err := client.StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &w.Spec.Template.Spec, nil
+	case "daemonset":
+		w, err := client.DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &w.Spec.Template.Spec, nil
+	}
+	return nil, fmt.Errorf("unsupported workload kind %q", kind)
+}
+
+// podSpecNodeSelectors translates the nodeSelector and the required node
+// affinity of the pod spec into node selectors (matching any of them). The
+// nodeSelector is combined with each of the node affinity terms, which are
+// OR'ed by the scheduler. Field (matchFields) requirements, preferred
+// affinity and taints/tolerations are not considered.
+func podSpecNodeSelectors(spec *corev1.PodSpec) ([]labels.Selector, error) {
+	base := labels.SelectorFromSet(spec.NodeSelector)
+
+	var terms []corev1.NodeSelectorTerm
+	if a := spec.Affinity; a != nil && a.NodeAffinity != nil && a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	}
+	if len(terms) == 0 {
+		return []labels.Selector{base}, nil
+	}
+
+	var out []labels.Selector
+	for _, term := range terms {
+		if len(term.MatchFields) > 0 {
+			klog.Warningf("ignoring the matchFields of the node affinity term (not supported)")
+		}
+		sel := base
+		for _, expr := range term.MatchExpressions {
+			req, err := nodeSelectorRequirement(expr)
+			if err != nil {
+				return nil, err
+			}
+			sel = sel.Add(*req)
+		}
+		out = append(out, sel)
+	}
+	return out, nil
+}
+
+// nodeSelectorRequirement converts a node affinity match expression into a
+// label selector requirement.
+func nodeSelectorRequirement(expr corev1.NodeSelectorRequirement) (*labels.Requirement, error) {
+	var op selection.Operator
+	switch expr.Operator {
+	case corev1.NodeSelectorOpIn:
+		op = selection.In
+	case corev1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case corev1.NodeSelectorOpExists:
+		op = selection.Exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case corev1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case corev1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return nil, fmt.Errorf("unsupported node selector operator %q", expr.Operator)
+	}
+	return labels.NewRequirement(expr.Key, op, expr.Values)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseWorkloadRef(t *testing.T) {
+	for ref, want := range map[string][2]string{
+		"deployment/web":  {"deployment", "web"},
+		"Deployment/web":  {"deployment", "web"},
+		"deploy/web":      {"deployment", "web"},
+		"sts/db":          {"statefulset", "db"},
+		"daemonsets/node": {"daemonset", "node"},
+	} {
+		kind, name, err := parseWorkloadRef(ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, want, [2]string{kind, name}, ref)
+	}
+	for _, ref := range []string{"web", "deployment/", "job/x"} {
+		_, _, err := parseWorkloadRef(ref)
+		require.Error(t, err, ref)
+	}
+}
+
+func TestPodSpecNodeSelectors(t *testing.T) {
+	strs := func(sels []labels.Selector) []string {
+		var out []string
+		for _, s := range sels {
+			out = append(out, s.String())
+		}
+		return out
+	}
+
+	t.Run("nodeSelector", func(t *testing.T) {
+		sels, err := podSpecNodeSelectors(&corev1.PodSpec{NodeSelector: map[string]string{"pool": "gpu", "kubernetes.io/os": "linux"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"kubernetes.io/os=linux,pool=gpu"}, strs(sels))
+		require.True(t, sels[0].Matches(labels.Set{"pool": "gpu", "kubernetes.io/os": "linux", "zone": "a"}))
+		require.False(t, sels[0].Matches(labels.Set{"pool": "gpu"}))
+	})
+	t.Run("unconstrained", func(t *testing.T) {
+		sels, err := podSpecNodeSelectors(&corev1.PodSpec{})
+		require.NoError(t, err)
+		require.Len(t, sels, 1)
+		require.True(t, sels[0].Matches(labels.Set{"any": "node"}))
+	})
+	t.Run("node affinity", func(t *testing.T) {
+		sels, err := podSpecNodeSelectors(&corev1.PodSpec{
+			NodeSelector: map[string]string{"pool": "gpu"},
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+						{Key: "spot", Operator: corev1.NodeSelectorOpDoesNotExist},
+					}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: "cores", Operator: corev1.NodeSelectorOpGt, Values: []string{"8"}},
+					}},
+				}},
+			}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"pool=gpu,!spot,zone in (a,b)", "cores>8,pool=gpu"}, strs(sels))
+	})
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := podSpecNodeSelectors(&corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: "Near"}}},
+			}},
+		}}})
+		require.ErrorContains(t, err, `"Near"`)
+	})
+}
+
+func TestGetWorkloadPodSpec(t *testing.T) {
+	client := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns1"},
+		Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"pool": "db"},
+		}}},
+	})
+	spec, err := getWorkloadPodSpec(context.Background(), client.AppsV1(), "ns1", "statefulset", "db")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"pool": "db"}, spec.NodeSelector)
+
+	_, err = getWorkloadPodSpec(context.Background(), client.AppsV1(), "ns2", "statefulset", "db")
+	require.Error(t, err)
+}